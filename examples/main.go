@@ -1,22 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/tensuqiuwulu/go-migration/migration"
+	"gorm.io/driver/mysql"
 	// Uncomment baris berikut untuk menggunakan koneksi database langsung
-	// "gorm.io/driver/mysql"
 	// "gorm.io/gorm"
-	
-	// Plugin package diperlukan untuk loadMigrations
-	_ "plugin"
+
+	// Import package migrations agar fungsi init() setiap file migrasi
+	// mendaftarkan migrasinya dengan migration.Register
+	"github.com/tensuqiuwulu/go-migration/examples/migrations"
 )
 
 func main() {
 	// Cek apakah ada argumen untuk migration
-	if len(os.Args) > 1 && (os.Args[1] == "make:migration" || os.Args[1] == "migrate" || os.Args[1] == "migrate:rollback") {
+	if len(os.Args) > 1 && (strings.HasPrefix(os.Args[1], "make:") || strings.HasPrefix(os.Args[1], "migrate") || strings.HasPrefix(os.Args[1], "db:")) {
 		// Cara 1: Menggunakan SetDatabaseConfig
+		// Driver yang dipakai harus didaftarkan terlebih dahulu, hanya driver yang
+		// didaftarkan yang ikut dikompilasi ke dalam aplikasi
+		migration.RegisterDriver("mysql", mysql.Open)
 		migration.SetDatabaseConfig("mysql", "root:password@tcp(localhost:3306)/example_db?charset=utf8mb4&parseTime=True&loc=Local")
 		
 		// Cara 2: Menginjeksi koneksi database yang sudah ada
@@ -31,13 +39,20 @@ func main() {
 		*/
 		
 		// CATATAN PENTING:
-		// Implementasi loadMigrations() menggunakan plugin Go untuk memuat migrasi secara dinamis.
-		// Pastikan semua file migrasi di direktori migrations/ menggunakan package "migrations".
-		// Struktur migrasi harus mengikuti format: Migration<timestamp><CamelCaseName>
-		// Contoh: Migration20240601000000CreateUsersTable
+		// Migrasi didaftarkan melalui migration.Register di fungsi init() setiap file migrasi,
+		// sehingga tidak perlu plugin Go maupun toolchain Go saat menjalankan migrasi.
+		// Untuk tetap memakai plugin Go, gunakan migration.SetMigrationSource(migration.PluginSource()).
+
+		// File migrasi SQL dibaca dari embed.FS, bukan dari direktori kerja
+		migration.SetMigrationsFS(migrations.SQLFiles, ".")
 		
-		// Jalankan perintah migration
-		migration.ExecuteCommand(os.Args[1:])
+		// Jalankan perintah migration, exit code menunjukkan jenis kegagalan
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := migration.Run(ctx, os.Args[1:]); err != nil {
+			stop()
+			os.Exit(migration.ExitCode(err))
+		}
 		return
 	}
 
@@ -45,6 +60,14 @@ func main() {
 	fmt.Println("Go-Migration Example")
 	fmt.Println("Available commands:")
 	fmt.Println("  make:migration <name> - Create a new migration file")
+	fmt.Println("  make:seeder <name> - Create a new seeder file")
 	fmt.Println("  migrate - Run all pending migrations")
 	fmt.Println("  migrate:rollback - Rollback the last batch of migrations")
+	fmt.Println("  migrate:reset - Rollback all migrations")
+	fmt.Println("  migrate:refresh - Rollback all migrations and run them again")
+	fmt.Println("  migrate:fresh - Drop all tables and run all migrations")
+	fmt.Println("  migrate:status - Show the status of each migration")
+	fmt.Println("  migrate:repair - Store the current checksum of applied migrations")
+	fmt.Println("  migrate:build - Compile the migrations into a plugin for hosts without Go")
+	fmt.Println("  db:seed - Run the registered seeders")
 }
\ No newline at end of file
@@ -1,9 +1,14 @@
 package migrations
 
 import (
+	"github.com/tensuqiuwulu/go-migration/migration"
 	"gorm.io/gorm"
 )
 
+func init() {
+	migration.Register("20240601000000", "create_users_table", &Migration20240601000000CreateUsersTable{})
+}
+
 type Migration20240601000000CreateUsersTable struct {}
 
 func (m *Migration20240601000000CreateUsersTable) Up(db *gorm.DB) error {
@@ -22,7 +27,3 @@ func (m *Migration20240601000000CreateUsersTable) Up(db *gorm.DB) error {
 func (m *Migration20240601000000CreateUsersTable) Down(db *gorm.DB) error {
 	return db.Exec(`DROP TABLE IF EXISTS users`).Error
 }
-
-// Export the migration struct for plugin system
-// This function name must match the struct name exactly
-var Migration20240601000000CreateUsersTable_Exported = &Migration20240601000000CreateUsersTable{}
\ No newline at end of file
@@ -1,9 +1,14 @@
 package migrations
 
 import (
+	"github.com/tensuqiuwulu/go-migration/migration"
 	"gorm.io/gorm"
 )
 
+func init() {
+	migration.Register("20240601000100", "create_products_table", &Migration20240601000100CreateProductsTable{})
+}
+
 type Product struct {
 	ID          uint    `gorm:"primaryKey"`
 	Name        string  `gorm:"size:255;not null"`
@@ -21,7 +26,3 @@ func (m *Migration20240601000100CreateProductsTable) Up(db *gorm.DB) error {
 func (m *Migration20240601000100CreateProductsTable) Down(db *gorm.DB) error {
 	return db.Migrator().DropTable("products")
 }
-
-// Export the migration struct for plugin system
-// This function name must match the struct name exactly
-var Migration20240601000100CreateProductsTable_Exported = &Migration20240601000100CreateProductsTable{}
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN phone VARCHAR(20) NULL AFTER email;
@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// SQLFiles berisi file migrasi SQL di direktori ini, dikompilasi ke dalam
+// binary sehingga migrasi bisa dijalankan dari direktori mana pun
+//
+//go:embed *.sql
+var SQLFiles embed.FS
@@ -0,0 +1,140 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+// ChecksummedMigration can be implemented by a migration to provide the
+// checksum stored when it is applied. Migrations loaded from files get the
+// checksum of their source automatically.
+type ChecksummedMigration interface {
+	Checksum() string
+}
+
+// ErrChecksumMismatch is returned in strict mode when an applied migration
+// was changed after it ran
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumBytes returns the hex encoded SHA-256 of data
+func checksumBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumFile returns the checksum of a source file, or an empty string when
+// the file cannot be read, for example in a binary deployed without sources
+func checksumFile(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return checksumBytes(data)
+}
+
+// migrationChecksum returns the checksum of a migration, if it has one
+func migrationChecksum(m Migration) string {
+	if c, ok := m.(ChecksummedMigration); ok {
+		return c.Checksum()
+	}
+	return ""
+}
+
+// checksumMismatch is an applied migration whose checksum changed
+type checksumMismatch struct {
+	migration *identifiedMigration
+	record    MigrationRecord
+}
+
+// findChecksumMismatches compares the checksum of every applied migration
+// with the stored one. Records without a checksum, such as those written
+// before checksums were tracked, are not compared.
+func findChecksumMismatches(migrations []*identifiedMigration, records []MigrationRecord) []checksumMismatch {
+	recordMap := make(map[string]MigrationRecord, len(records))
+	for _, record := range records {
+		recordMap[record.Version] = record
+	}
+
+	var mismatches []checksumMismatch
+	for _, migration := range migrations {
+		record, ok := recordMap[migration.version]
+		if !ok || record.Checksum == "" || migration.checksum == "" {
+			continue
+		}
+		if record.Checksum != migration.checksum {
+			mismatches = append(mismatches, checksumMismatch{migration: migration, record: record})
+		}
+	}
+	return mismatches
+}
+
+// checkChecksums reports applied migrations that were changed, and fails in
+// strict mode
+func checkChecksums(logger *slog.Logger, migrations []*identifiedMigration, records []MigrationRecord, strict bool) error {
+	mismatches := findChecksumMismatches(migrations, records)
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	for _, mismatch := range mismatches {
+		logger.Warn("migration was changed after it was applied", "version", mismatch.migration.version, "name", mismatch.migration.name, "batch", mismatch.record.Batch)
+	}
+
+	if strict {
+		return fmt.Errorf("%w: %d applied migration(s) were changed, run migrate:repair after a deliberate change", ErrChecksumMismatch, len(mismatches))
+	}
+	return nil
+}
+
+// RepairChecksums stores the current checksum of every applied migration,
+// acknowledging deliberate changes to migrations that already ran
+func RepairChecksums(db *gorm.DB, migrations []Migration, opts ...RunOption) error {
+	return defaultMigrator.withMigrations(db, migrations).Repair(opts...)
+}
+
+// repairChecksums stores the current checksum of every applied migration
+func repairChecksums(db *gorm.DB, migrations []Migration, config *runConfig) error {
+	identifiedMigrations, err := identifyMigrations(migrations)
+	if err != nil {
+		return err
+	}
+
+	records, err := getAppliedRecords(db, config.table, identifiedMigrations, false)
+	if err != nil {
+		return err
+	}
+
+	recordMap := make(map[string]MigrationRecord, len(records))
+	for _, record := range records {
+		recordMap[record.Version] = record
+	}
+
+	repaired := 0
+	for _, migration := range identifiedMigrations {
+		record, ok := recordMap[migration.version]
+		if !ok || migration.checksum == "" || record.Checksum == migration.checksum {
+			continue
+		}
+
+		err := db.Table(config.table).Where("id = ?", record.ID).Update("checksum", migration.checksum).Error
+		if err != nil {
+			return fmt.Errorf("failed to update checksum of %s: %w", migration.key(), err)
+		}
+		config.logger.Info("updated checksum", "version", migration.version, "name", migration.name)
+		repaired++
+	}
+
+	if repaired == 0 {
+		config.logger.Info("nothing to repair")
+	}
+	return nil
+}
@@ -1,282 +1,553 @@
 package migration
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"plugin"
-	"reflect"
-	"sort"
-	"strings"
-
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-// Database connection configuration
-var (
-	dbDialect string = "mysql"
-	dbDSN     string = "user:password@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=True&loc=Local"
-	dbConnection *gorm.DB = nil
-)
-
-// SetDatabaseConfig sets the database configuration
+// SetDatabaseConfig sets the database configuration. The dialect must have a
+// driver registered with RegisterDriver. URL-style DSNs (postgres://...,
+// mysql://..., sqlserver://..., sqlite://file.db) select the dialect themselves.
 func SetDatabaseConfig(dialect, dsn string) {
-	dbDialect = dialect
-	dbDSN = dsn
+	defaultMigrator.dialect = dialect
+	defaultMigrator.dsn = dsn
+	defaultMigrator.conn = &connection{}
 }
 
 // SetDatabaseConnection sets an existing database connection
 func SetDatabaseConnection(db *gorm.DB) {
-	dbConnection = db
+	defaultMigrator.db = db
 }
 
-// getDatabase returns a database connection
-func getDatabase() (*gorm.DB, error) {
-	// If a database connection has been injected, use it
-	if dbConnection != nil {
-		return dbConnection, nil
+// connect returns the database connection, logging the error when it cannot
+// be opened
+func (m *Migrator) connect() (*gorm.DB, error) {
+	db, err := m.DB()
+	if err != nil {
+		m.logger.Error("failed to connect to database", "error", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	
-	// Otherwise, this is a placeholder. In a real implementation, you would use GORM to connect to the database
-	// For example:
-	// import "gorm.io/driver/mysql"
-	// return gorm.Open(mysql.Open(dbDSN), &gorm.Config{})
-	//
-	// Or for PostgreSQL:
-	// import "gorm.io/driver/postgres"
-	// return gorm.Open(postgres.Open(dbDSN), &gorm.Config{})
-	
-	// For now, we'll return an error to remind users to either:
-	// 1. Implement this function with the appropriate database driver
-	// 2. Inject a database connection using SetDatabaseConnection
-	
-	return nil, fmt.Errorf("database connection not implemented, please either:\n" +
-		"1. Implement getDatabase() function with your database driver\n" +
-		"2. Inject a database connection using SetDatabaseConnection")
+	return db, nil
 }
 
-// loadMigrations loads all migrations from the migrations directory
-func loadMigrations() ([]Migration, error) {
-	// Get current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current working directory: %w", err)
+// logFlags are the flags every command accepts to control its log output
+type logFlags struct {
+	quiet   *bool
+	verbose bool
+	format  *string
+}
+
+// addLogFlags registers --quiet, -v and --log-format
+func addLogFlags(flags *flag.FlagSet) *logFlags {
+	f := &logFlags{
+		quiet:  flags.Bool("quiet", false, "only log errors"),
+		format: flags.String("log-format", "", "log as `text` or json"),
+	}
+	flags.BoolVar(&f.verbose, "v", false, "also log debug events")
+	flags.BoolVar(&f.verbose, "verbose", false, "also log debug events")
+	return f
+}
+
+// apply returns a copy of m logging to stderr as the flags ask for, or m
+// itself when no flag was given
+func (f *logFlags) apply(m *Migrator) (*Migrator, error) {
+	if !*f.quiet && !f.verbose && *f.format == "" {
+		return m, nil
 	}
-	log.Printf("Current working directory: %s", cwd)
-	
-	// Check if migrations directory exists
-	migrationsDir := "migrations"
-	migrationsPath := filepath.Join(cwd, migrationsDir)
-	
-	// Check if migrations directory exists
-	if _, statErr := os.Stat(migrationsPath); os.IsNotExist(statErr) {
-		log.Printf("Migrations directory not found at: %s", migrationsPath)
-		return nil, fmt.Errorf("migrations directory not found: %w", err)
+
+	level := slog.LevelInfo
+	switch {
+	case *f.quiet:
+		level = slog.LevelError
+	case f.verbose:
+		level = slog.LevelDebug
 	}
-	
-	// Get all migration files
-	files, err := os.ReadDir(migrationsDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch *f.format {
+	case "", "text":
+		return m.withLogger(slog.New(slog.NewTextHandler(os.Stderr, opts))), nil
+	case "json":
+		return m.withLogger(slog.New(slog.NewJSONHandler(os.Stderr, opts))), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", *f.format)
 	}
+}
+
+// pathFlags are the flags every command accepts to locate the migrations.
+// Each defaults to an environment variable, and the Migrator's own setting is
+// kept when neither is given.
+type pathFlags struct {
+	dir          *string
+	createDir    *string
+	pluginOutput *string
+	pluginCache  *string
+	rebuild      *bool
+	plugin       *string
+	seedersDir   *string
+}
 
-	// log files
-	log.Printf("Found %d files in migrations directory", len(files))
-	for i, file := range files {
-		log.Printf("Migration file %d: %s", i+1, file.Name())
+// addPathFlags registers --migrations-dir, --create-dir, --plugin-output,
+// --plugin-cache, --rebuild, --plugin and --seeders-dir
+func addPathFlags(flags *flag.FlagSet) *pathFlags {
+	return &pathFlags{
+		dir:          flags.String("migrations-dir", os.Getenv("MIGRATIONS_DIR"), "load migrations from `dir` (env MIGRATIONS_DIR)"),
+		createDir:    flags.String("create-dir", os.Getenv("MIGRATIONS_CREATE_DIR"), "create new migrations in `dir` (env MIGRATIONS_CREATE_DIR)"),
+		pluginOutput: flags.String("plugin-output", os.Getenv("MIGRATIONS_PLUGIN_OUTPUT"), "build the migrations plugin to `path`, bypassing the cache (env MIGRATIONS_PLUGIN_OUTPUT)"),
+		pluginCache:  flags.String("plugin-cache", os.Getenv("MIGRATIONS_PLUGIN_CACHE"), "cache compiled migrations plugins in `dir` (env MIGRATIONS_PLUGIN_CACHE)"),
+		rebuild:      flags.Bool("rebuild", false, "compile the migrations plugin even when a cached build matches"),
+		plugin:       flags.String("plugin", os.Getenv("MIGRATIONS_PLUGIN"), "load migrations from the plugin at `path` built by migrate:build instead of compiling them (env MIGRATIONS_PLUGIN)"),
+		seedersDir:   flags.String("seeders-dir", os.Getenv("MIGRATIONS_SEEDERS_DIR"), "create new seeders in `dir` (env MIGRATIONS_SEEDERS_DIR)"),
 	}
-	
-	// Sort files by name to ensure migrations run in order
-	filenames := make([]string, 0, len(files))
-	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".go" {
-			filenames = append(filenames, file.Name())
-		}
+}
+
+// apply returns a copy of m using the directories given by the flags
+func (f *pathFlags) apply(m *Migrator) *Migrator {
+	c := *m
+	if *f.dir != "" {
+		c.dir = *f.dir
 	}
-	sort.Strings(filenames)
-	
-	// Compile the migrations directory into a plugin
-	log.Printf("Compiling migrations directory into plugin...")
-	
-	// Use absolute path for migrations directory and output file
-	pluginOutputPath := filepath.Join(cwd, "migrations.so")
-	
-	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", pluginOutputPath, migrationsPath)
-	
-	// Capture command output for debugging
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	log.Printf("Running command: go build -buildmode=plugin -o %s %s", pluginOutputPath, migrationsPath)
-	
-	if cmdErr := cmd.Run(); cmdErr != nil {
-		log.Printf("Error compiling migrations: %v", cmdErr)
-		return nil, fmt.Errorf("failed to compile migrations: %w", cmdErr)
+	if *f.createDir != "" {
+		c.createDir = *f.createDir
 	}
-	
-	log.Printf("Successfully compiled migrations plugin at: %s", pluginOutputPath)
-	
-	// Load the plugin using absolute path
-	log.Printf("Loading plugin from: %s", pluginOutputPath)
-	p, err := plugin.Open(pluginOutputPath)
-	if err != nil {
-		log.Printf("Error loading plugin: %v", err)
-		return nil, fmt.Errorf("failed to load migrations plugin: %w", err)
+	if *f.pluginOutput != "" {
+		c.pluginOutput = *f.pluginOutput
 	}
-	
-	log.Printf("Successfully loaded migrations plugin")
-	
-	// Load each migration
-	log.Printf("Loading migrations from plugin...")
-	migrations := make([]Migration, 0, len(filenames))
-	
-	for i, filename := range filenames {
-		log.Printf("Processing migration file %d/%d: %s", i+1, len(filenames), filename)
-		
-		// Extract struct name from filename
-		// Format: YYYYMMDDHHMMSS_migration_name.go
-		parts := strings.Split(strings.TrimSuffix(filename, ".go"), "_")
-		if len(parts) < 2 {
-			log.Printf("Skipping %s: doesn't follow naming convention", filename)
-			continue // Skip files that don't follow the naming convention
-		}
-		
-		// Construct the struct name: Migration<timestamp><CamelCaseName>
-		timestamp := parts[0]
-		nameParts := parts[1:]
-		
-		// Convert to camel case
-		var camelCaseName string
-		for _, part := range nameParts {
-			camelCaseName += cases.Title(language.Und).String(part)
-		}
-		
-		// The struct name in the migration file is already prefixed with "Migration"
-		structName := "Migration" + timestamp + camelCaseName
-		// The exported variable name has _Exported suffix
-		exportedVarName := structName + "_Exported"
-		log.Printf("Looking for exported variable: %s", exportedVarName)
-		
-		// Look up exported variable in the plugin
-		sym, err := p.Lookup(exportedVarName)
-		if err != nil {
-			log.Printf("Error looking up exported variable %s: %v", exportedVarName, err)
-			// Try the original struct name as fallback
-			sym, err = p.Lookup(structName)
-			if err != nil {
-				log.Printf("Error looking up migration %s: %v", structName, err)
-				return nil, fmt.Errorf("failed to lookup migration %s or %s: %w", exportedVarName, structName, err)
-			}
-			log.Printf("Found migration using original struct name: %s", structName)
-		} else {
-			log.Printf("Found migration using exported variable: %s", exportedVarName)
-		}
-		
-		log.Printf("Found symbol for %s, checking if it implements Migration interface", structName)
-		
-		// Try to convert the symbol to a Migration interface
-		log.Printf("Symbol type: %T", sym)
-		
-		// First, check if it's a pointer to a struct that implements Migration
-		if migration, ok := sym.(Migration); ok {
-			log.Printf("Successfully loaded migration: %s (direct interface)", structName)
-			migrations = append(migrations, migration)
-			continue
-		}
-		
-		// Next, check if it's a pointer to a struct that we need to dereference
-		// This is a more generic approach that doesn't rely on knowing the exact struct type
-		valueOfSym := reflect.ValueOf(sym)
-		log.Printf("Symbol value kind: %v, can interface? %v", valueOfSym.Kind(), valueOfSym.CanInterface())
-		
-		if valueOfSym.Kind() == reflect.Ptr && valueOfSym.Elem().CanInterface() {
-			// Try to get the concrete value and check if it implements Migration
-			concrete := valueOfSym.Elem().Interface()
-			log.Printf("Concrete type: %T", concrete)
-			
-			if migration, ok := concrete.(Migration); ok {
-				log.Printf("Successfully loaded migration: %s (via reflection)", structName)
-				migrations = append(migrations, migration)
-				continue
-			}
+	if *f.pluginCache != "" {
+		c.pluginCacheDir = *f.pluginCache
+	}
+	if *f.rebuild {
+		c.rebuildPlugin = true
+	}
+	if *f.plugin != "" {
+		c.plugin = *f.plugin
+	}
+	if *f.seedersDir != "" {
+		c.seedersDir = *f.seedersDir
+	}
+	return &c
+}
+
+// parseFlags registers the flags every command accepts, parses the arguments
+// of a command and returns the Migrator it runs with
+func (m *Migrator) parseFlags(flags *flag.FlagSet, args []string) (*Migrator, error) {
+	logging := addLogFlags(flags)
+	paths := addPathFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
 		}
-		
-		// If we get here, we couldn't convert the symbol to a Migration
-		log.Printf("%s does not implement Migration interface", structName)
-		return nil, fmt.Errorf("%s does not implement Migration interface", structName)
+		return nil, fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	m, err := logging.apply(paths.apply(m))
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		return nil, fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	return m, nil
+}
+
+// runFlags are the flags shared by the commands that change the database
+type runFlags struct {
+	lockTimeout *time.Duration
+	timeout     *time.Duration
+}
+
+// addRunFlags registers --lock-timeout and --timeout
+func addRunFlags(flags *flag.FlagSet) *runFlags {
+	return &runFlags{
+		lockTimeout: flags.Duration("lock-timeout", defaultLockTimeout, "how long to wait for the migration lock, 0 waits forever"),
+		timeout:     flags.Duration("timeout", 0, "how long each migration may run, 0 means no limit"),
 	}
-	
-	log.Printf("Successfully loaded %d migrations", len(migrations))
-	
-	return migrations, nil
 }
 
+// options runs a command with ctx. Runs wait for the lock of the Migrator, so
+// concurrent invocations wait for each other.
+func (f *runFlags) options(ctx context.Context) []RunOption {
+	return []RunOption{
+		WithContext(ctx),
+		WithLockTimeout(*f.lockTimeout),
+		WithTimeout(*f.timeout),
+	}
+}
+
+// interruptContext returns a context cancelled by SIGINT or SIGTERM. The
+// running migration is aborted and no further migration is started, a second
+// signal terminates the process right away.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// ExecuteCommand runs a command of the default Migrator. Errors are only
+// logged, use Run to exit with a status that tells them apart.
 func ExecuteCommand(args []string) {
+	defaultMigrator.Execute(args)
+}
+
+// Run runs a command of the default Migrator, see Migrator.Run
+func Run(ctx context.Context, args []string) error {
+	return defaultMigrator.Run(ctx, args)
+}
+
+// Execute runs a command like Run, cancelling it on SIGINT or SIGTERM.
+// Errors are only logged.
+func (m *Migrator) Execute(args []string) {
+	ctx, stop := interruptContext()
+	defer stop()
+
+	_ = m.Run(ctx, args)
+}
+
+// Run runs a command such as migrate or migrate:rollback, args are the command
+// line arguments without the program name. Progress and failures are logged,
+// the returned error wraps ErrUnknownCommand, ErrUsage, ErrLockTimeout,
+// ErrChecksumMismatch, ErrInterrupted or a *MigrationError, see ExitCode.
+// Cancelling ctx aborts the running migration.
+func (m *Migrator) Run(ctx context.Context, args []string) error {
+	err := m.runCommand(ctx, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// printHelp lists the available commands
+func (m *Migrator) printHelp() {
+	fmt.Fprintln(m.output, "Available commands:")
+	fmt.Fprintln(m.output, "  make:migration <name> - Create a new migration file")
+	fmt.Fprintln(m.output, "  migrate:build [--output=<path>] - Compile the migrations into a plugin loaded with --plugin on hosts without a Go toolchain")
+	fmt.Fprintln(m.output, "  make:seeder <name> - Create a new seeder file")
+	fmt.Fprintln(m.output, "  migrate [--to=<version>] [--out-of-order=error|warn|allow] [--ignore-missing] [--pretend] [--strict] [--seed] - Run all pending migrations, or those up to a version, then optionally the seeders")
+	fmt.Fprintln(m.output, "  migrate:rollback [--step=N | --batch=N | --to=<version>] [--pretend] - Rollback the last batch, the last N migrations, batch N or every migration newer than a version")
+	fmt.Fprintln(m.output, "  migrate:reset [--pretend] - Rollback all migrations")
+	fmt.Fprintln(m.output, "  migrate:refresh - Rollback all migrations and run them again")
+	fmt.Fprintln(m.output, "  migrate:fresh - Drop all tables and run all migrations")
+	fmt.Fprintln(m.output, "  migrate:status - Show the status of each migration")
+	fmt.Fprintln(m.output, "  db:seed [--class=<name>] [--force] - Run the registered seeders in registration order, or a single one")
+	fmt.Fprintln(m.output, "  migrate:repair - Store the current checksum of applied migrations")
+	fmt.Fprintln(m.output, "Commands that change the database accept --lock-timeout=<duration> (default 5m) and --timeout=<duration> per migration")
+	fmt.Fprintln(m.output, "Every command accepts --quiet, -v and --log-format=text|json, logs are written to stderr")
+	fmt.Fprintln(m.output, "Every command accepts --migrations-dir, --create-dir, --seeders-dir, --plugin-output and --plugin-cache, or the MIGRATIONS_DIR, MIGRATIONS_CREATE_DIR, MIGRATIONS_SEEDERS_DIR, MIGRATIONS_PLUGIN_OUTPUT and MIGRATIONS_PLUGIN_CACHE environment variables")
+	fmt.Fprintln(m.output, "Compiled migrations plugins are cached until their sources, the local packages they import, go.mod, go.sum or the Go version change, --rebuild compiles them regardless")
+	fmt.Fprintln(m.output, "Every command accepts --plugin=<path>, or the MIGRATIONS_PLUGIN environment variable, to load a plugin built by migrate:build")
+}
+
+// runCommand dispatches a command, Run turns a -h request into success
+func (m *Migrator) runCommand(ctx context.Context, args []string) error {
 	if len(args) < 1 {
-		fmt.Println("Available commands:")
-		fmt.Println("  make:migration <name> - Create a new migration file")
-		fmt.Println("  migrate - Run all pending migrations")
-		fmt.Println("  migrate:rollback - Rollback the last batch of migrations")
-		return
+		m.printHelp()
+		return nil
 	}
 
 	switch args[0] {
 	case "make:migration":
-		if len(args) < 2 {
-			fmt.Println("Please specify migration name")
-			return
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		if flags.NArg() < 1 {
+			m.logger.Error("please specify migration name")
+			return fmt.Errorf("%w: make:migration requires a migration name", ErrUsage)
+		}
+		if err := m.Create(flags.Arg(0)); err != nil {
+			m.logger.Error("failed to create migration", "error", err)
+			return err
+		}
+		return nil
+
+	case "make:seeder":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		if flags.NArg() < 1 {
+			m.logger.Error("please specify seeder name")
+			return fmt.Errorf("%w: make:seeder requires a seeder name", ErrUsage)
 		}
-		if err := CreateMigration(args[1]); err != nil {
-			fmt.Printf("Error creating migration: %v\n", err)
-		} else {
-			fmt.Println("Migration created successfully")
+		if err := m.CreateSeeder(flags.Arg(0)); err != nil {
+			m.logger.Error("failed to create seeder", "error", err)
+			return err
 		}
+		return nil
+
+	case "db:seed":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		class := flags.String("class", "", "only run the seeder registered as `name`")
+		force := flags.Bool("force", false, "run run-once seeders again even if they were recorded")
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		m.logger.Info("seeding database")
+		if _, err := m.connect(); err != nil {
+			return err
+		}
+
+		opts := shared.options(ctx)
+		if *class != "" {
+			opts = append(opts, WithSeederClass(*class))
+		}
+		if *force {
+			opts = append(opts, WithForceSeed())
+		}
+
+		if err := m.Seed(opts...); err != nil {
+			m.logger.Error("failed to seed database", "error", err)
+			return err
+		}
+		m.logger.Info("seeding completed")
+		return nil
+
+	case "migrate:build":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		output := flags.String("output", "", "write the plugin to `path` (default --plugin-output or migrations.so)")
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		path := *output
+		if path == "" {
+			path = m.pluginOutput
+		}
+		if path == "" {
+			path = "migrations.so"
+		}
+		if err := m.Build(path); err != nil {
+			m.logger.Error("failed to build migrations plugin", "error", err)
+			return err
+		}
+		return nil
+
 	case "migrate":
-		fmt.Println("Running migrations...")
-		db, err := getDatabase()
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		pretend := flags.Bool("pretend", false, "print the SQL of pending migrations without running them")
+		strict := flags.Bool("strict", false, "refuse to run when an applied migration was changed")
+		to := flags.String("to", "", "only run pending migrations up to and including `version`")
+		ignoreMissing := flags.Bool("ignore-missing", false, "run even when applied migrations are no longer present")
+		outOfOrder := flags.String("out-of-order", string(OutOfOrderError), "what to do with pending migrations older than the newest applied one: error, warn or allow")
+		seed := flags.Bool("seed", false, "run the registered seeders after migrating")
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
 		if err != nil {
-			fmt.Printf("Error connecting to database: %v\n", err)
-			return
+			return err
 		}
-		
-		migrations, err := loadMigrations()
+		if *seed && *pretend {
+			fmt.Fprintln(flags.Output(), "--seed cannot be used with --pretend")
+			return fmt.Errorf("%w: --seed cannot be used with --pretend", ErrUsage)
+		}
+
+		policy, err := parseOutOfOrderPolicy(*outOfOrder)
 		if err != nil {
-			fmt.Printf("Error loading migrations: %v\n", err)
-			return
+			fmt.Fprintln(flags.Output(), err)
+			return fmt.Errorf("%w: %v", ErrUsage, err)
+		}
+
+		m.logger.Info("running migrations")
+		if _, err := m.connect(); err != nil {
+			return err
+		}
+
+		opts := append(shared.options(ctx), WithOutOfOrder(policy))
+		if *pretend {
+			opts = append(opts, WithPretend())
+		}
+		if *strict {
+			opts = append(opts, WithStrictChecksums())
+		}
+		if *ignoreMissing {
+			opts = append(opts, WithIgnoreMissing())
+		}
+		if *to != "" {
+			opts = append(opts, WithTarget(*to))
 		}
-		
-		if err := RunMigrations(db, migrations); err != nil {
-			fmt.Printf("Error running migrations: %v\n", err)
-		} else {
-			fmt.Println("Migrations completed successfully")
+
+		if err := m.Up(opts...); err != nil {
+			m.logger.Error("failed to run migrations", "error", err)
+			return err
 		}
-		
+		m.logger.Info("migrations completed")
+
+		if *seed {
+			m.logger.Info("seeding database")
+			if err := m.Seed(shared.options(ctx)...); err != nil {
+				m.logger.Error("failed to seed database", "error", err)
+				return err
+			}
+			m.logger.Info("seeding completed")
+		}
+		return nil
+
 	case "migrate:rollback":
-		fmt.Println("Rolling back migrations...")
-		db, err := getDatabase()
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		step := flags.Int("step", 0, "rollback the last `N` migrations across batches")
+		batch := flags.Int("batch", 0, "rollback the migrations of batch `N`")
+		to := flags.String("to", "", "rollback every migration newer than `version`")
+		pretend := flags.Bool("pretend", false, "print the SQL of the rollback without running it")
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
 		if err != nil {
-			fmt.Printf("Error connecting to database: %v\n", err)
-			return
+			return err
+		}
+
+		m.logger.Info("rolling back migrations")
+		if _, err := m.connect(); err != nil {
+			return err
+		}
+
+		opts := append(shared.options(ctx), WithStep(*step), WithBatch(*batch), WithTarget(*to))
+		if *pretend {
+			opts = append(opts, WithPretend())
 		}
-		
-		migrations, err := loadMigrations()
+
+		if err := m.Down(opts...); err != nil {
+			m.logger.Error("failed to roll back migrations", "error", err)
+			return err
+		}
+		m.logger.Info("rollback completed")
+		return nil
+
+	case "migrate:reset":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		pretend := flags.Bool("pretend", false, "print the SQL of the rollback without running it")
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
 		if err != nil {
-			fmt.Printf("Error loading migrations: %v\n", err)
-			return
+			return err
 		}
-		
-		if err := RollbackMigrations(db, migrations); err != nil {
-			fmt.Printf("Error rolling back migrations: %v\n", err)
-		} else {
-			fmt.Println("Rollback completed successfully")
+
+		m.logger.Info("resetting migrations")
+		if _, err := m.connect(); err != nil {
+			return err
 		}
-		
+
+		opts := shared.options(ctx)
+		if *pretend {
+			opts = append(opts, WithPretend())
+		}
+
+		if err := m.Reset(opts...); err != nil {
+			m.logger.Error("failed to reset migrations", "error", err)
+			return err
+		}
+		m.logger.Info("reset completed")
+		return nil
+
+	case "migrate:refresh":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		m.logger.Info("refreshing migrations")
+		if _, err := m.connect(); err != nil {
+			return err
+		}
+
+		if err := m.Refresh(shared.options(ctx)...); err != nil {
+			m.logger.Error("failed to refresh migrations", "error", err)
+			return err
+		}
+		m.logger.Info("refresh completed")
+		return nil
+
+	case "migrate:fresh":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		m.logger.Info("dropping all tables and running migrations")
+		if _, err := m.connect(); err != nil {
+			return err
+		}
+
+		if err := m.Fresh(shared.options(ctx)...); err != nil {
+			m.logger.Error("failed to run fresh migrations", "error", err)
+			return err
+		}
+		m.logger.Info("fresh migration completed")
+		return nil
+
+	case "migrate:status":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		statuses, err := m.Status()
+		if err != nil {
+			m.logger.Error("failed to get migration status", "error", err)
+			return err
+		}
+
+		printStatus(m.output, statuses)
+
+		missing, modified := 0, 0
+		for _, status := range statuses {
+			if status.Missing {
+				missing++
+			}
+			if status.Modified {
+				modified++
+			}
+		}
+		if missing > 0 {
+			m.logger.Warn("applied migrations are no longer present, migrate refuses to run until they are restored or --ignore-missing is used", "count", missing)
+		}
+		if modified > 0 {
+			m.logger.Warn("applied migrations were changed, run migrate:repair after a deliberate change", "count", modified)
+		}
+		return nil
+
+	case "migrate:repair":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		shared := addRunFlags(flags)
+		m, err := m.parseFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+
+		m.logger.Info("repairing migration checksums")
+		if _, err := m.connect(); err != nil {
+			return err
+		}
+
+		if err := m.Repair(shared.options(ctx)...); err != nil {
+			m.logger.Error("failed to repair checksums", "error", err)
+			return err
+		}
+		m.logger.Info("repair completed")
+		return nil
+
 	default:
-		fmt.Println("Unknown command")
+		m.logger.Error("unknown command", "command", args[0])
+		m.printHelp()
+		return fmt.Errorf("%w %q", ErrUnknownCommand, args[0])
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,94 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ContextMigration can be implemented by a migration to receive the context of
+// the run. UpContext and DownContext are called instead of Up and Down, and
+// the database they get is already bound to ctx.
+type ContextMigration interface {
+	UpContext(ctx context.Context, db *gorm.DB) error
+	DownContext(ctx context.Context, db *gorm.DB) error
+}
+
+// TimeoutMigration can be implemented by a migration to limit how long it may
+// run, overriding WithTimeout. A zero duration means no limit.
+type TimeoutMigration interface {
+	Timeout() time.Duration
+}
+
+// ErrInterrupted is returned when the context of a run is cancelled, for
+// example by SIGINT, before every migration has been applied
+var ErrInterrupted = errors.New("migration run interrupted")
+
+// migrationTimeout returns the timeout of a migration
+func migrationTimeout(config *runConfig, migration Migration) time.Duration {
+	if t, ok := unwrapMigration(migration).(TimeoutMigration); ok {
+		return t.Timeout()
+	}
+	return config.timeout
+}
+
+// migrationContext returns the context a migration runs with
+func migrationContext(config *runConfig, migration Migration) (context.Context, context.CancelFunc) {
+	if timeout := migrationTimeout(config, migration); timeout > 0 {
+		return context.WithTimeout(config.ctx, timeout)
+	}
+	return context.WithCancel(config.ctx)
+}
+
+// callUp runs a migration, through UpContext when it implements ContextMigration
+func callUp(ctx context.Context, db *gorm.DB, migration Migration) error {
+	db = db.WithContext(ctx)
+	if m, ok := unwrapMigration(migration).(ContextMigration); ok {
+		return m.UpContext(ctx, db)
+	}
+	return migration.Up(db)
+}
+
+// callDown reverts a migration, through DownContext when it implements
+// ContextMigration
+func callDown(ctx context.Context, db *gorm.DB, migration Migration) error {
+	db = db.WithContext(ctx)
+	if m, ok := unwrapMigration(migration).(ContextMigration); ok {
+		return m.DownContext(ctx, db)
+	}
+	return migration.Down(db)
+}
+
+// checkInterrupted stops a run before the next migration once its context is
+// done
+func checkInterrupted(config *runConfig, action string, migration *identifiedMigration) error {
+	if err := config.ctx.Err(); err != nil {
+		return &MigrationError{Version: migration.version, Name: migration.name, Action: action, Err: fmt.Errorf("%w before it started: %v", ErrInterrupted, err)}
+	}
+	return nil
+}
+
+// interruptedError makes an error returned while the run was being cancelled
+// name the migration and wrap ErrInterrupted, unless it already does
+func interruptedError(config *runConfig, action string, migration *identifiedMigration, err error) error {
+	var migrationErr *MigrationError
+	if config.ctx.Err() == nil || errors.As(err, &migrationErr) {
+		return err
+	}
+	return &MigrationError{Version: migration.version, Name: migration.name, Action: action, Err: fmt.Errorf("%w: %v", ErrInterrupted, err)}
+}
+
+// migrationError wraps the error of a failed migration, telling apart a run
+// that was interrupted and a migration that ran out of time
+func migrationError(ctx context.Context, config *runConfig, action string, migration *identifiedMigration, err error) error {
+	switch {
+	case config.ctx.Err() != nil:
+		err = fmt.Errorf("%w: %v", ErrInterrupted, err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("timed out after %s: %w", migrationTimeout(config, migration), err)
+	}
+	return &MigrationError{Version: migration.version, Name: migration.name, Action: action, Err: err}
+}
@@ -0,0 +1,79 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownCommand is returned by Run for a command it does not know
+	ErrUnknownCommand = errors.New("unknown command")
+
+	// ErrUsage is returned by Run when the arguments of a command are invalid
+	ErrUsage = errors.New("invalid usage")
+)
+
+// MigrationError is returned when the Up or Down method of a migration fails.
+// It wraps ErrInterrupted when the run was cancelled during the migration.
+type MigrationError struct {
+	Version string
+	Name    string
+	// Action is "run" or "rollback"
+	Action string
+	Err    error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("failed to %s migration %s: %v", e.Action, migrationKey(e.Version, e.Name), e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Exit codes returned by ExitCode
+const (
+	ExitOK                = 0
+	ExitError             = 1
+	ExitUsage             = 2
+	ExitMigrationFailed   = 3
+	ExitChecksumMismatch  = 4
+	ExitLockTimeout       = 5
+	ExitOutOfOrder        = 6
+	ExitMissingMigrations = 7
+	// ExitInterrupted follows the shell convention for SIGINT
+	ExitInterrupted = 130
+)
+
+// ExitCode maps an error returned by Run to a process exit code, so main can
+// report the kind of failure to CI or an init container:
+//
+//	if err := migration.Run(ctx, os.Args[1:]); err != nil {
+//		os.Exit(migration.ExitCode(err))
+//	}
+func ExitCode(err error) int {
+	var migrationErr *MigrationError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrLockLost):
+		// The run was cancelled by the lost lock rather than by a signal
+		return ExitLockTimeout
+	case errors.Is(err, ErrInterrupted):
+		return ExitInterrupted
+	case errors.Is(err, ErrLockTimeout):
+		return ExitLockTimeout
+	case errors.Is(err, ErrChecksumMismatch):
+		return ExitChecksumMismatch
+	case errors.Is(err, ErrOutOfOrder):
+		return ExitOutOfOrder
+	case errors.Is(err, ErrMissingMigrations):
+		return ExitMissingMigrations
+	case errors.As(err, &migrationErr):
+		return ExitMigrationFailed
+	case errors.Is(err, ErrUnknownCommand), errors.Is(err, ErrUsage), errors.Is(err, ErrUnknownSeeder):
+		return ExitUsage
+	default:
+		return ExitError
+	}
+}
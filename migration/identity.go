@@ -0,0 +1,225 @@
+package migration
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// VersionedMigration can be implemented by a migration to declare its
+// version. Versions are compared as strings, so timestamps such as
+// 20240601000000 order migrations chronologically.
+type VersionedMigration interface {
+	Version() string
+}
+
+// NamedMigration can be implemented by a migration to declare its name
+type NamedMigration interface {
+	Name() string
+}
+
+// identifiedMigration attaches the version and name known to a loader, such as
+// Register or the filename of a plugin migration, to a migration
+type identifiedMigration struct {
+	Migration
+	version  string
+	name     string
+	checksum string
+}
+
+func (m *identifiedMigration) Version() string {
+	return m.version
+}
+
+func (m *identifiedMigration) Name() string {
+	return m.name
+}
+
+// Checksum returns the checksum of the migration source, if it is known
+func (m *identifiedMigration) Checksum() string {
+	if m.checksum != "" {
+		return m.checksum
+	}
+	return migrationChecksum(m.Migration)
+}
+
+// key returns the <version>_<name> identifier of the migration
+func (m *identifiedMigration) key() string {
+	return migrationKey(m.version, m.name)
+}
+
+// withIdentity wraps a migration so it reports the given version, name and
+// source checksum
+func withIdentity(m Migration, version, name, checksum string) Migration {
+	return &identifiedMigration{Migration: unwrapMigration(m), version: version, name: name, checksum: checksum}
+}
+
+// unwrapMigration returns the migration written by the user, so optional
+// interfaces such as NoTransactionMigration are checked on it
+func unwrapMigration(m Migration) Migration {
+	for {
+		wrapped, ok := m.(*identifiedMigration)
+		if !ok {
+			return m
+		}
+		m = wrapped.Migration
+	}
+}
+
+// typeNamePattern matches the Migration<timestamp><CamelCaseName> struct names
+// generated by CreateMigration
+var typeNamePattern = regexp.MustCompile(`Migration(\d+)([A-Za-z0-9]*)$`)
+
+// migrationID returns the version and name of a migration, taken from its
+// Version and Name methods or otherwise from the generated struct name
+func migrationID(m Migration) (version, name string) {
+	if v, ok := m.(VersionedMigration); ok {
+		version = v.Version()
+	}
+	if n, ok := m.(NamedMigration); ok {
+		name = n.Name()
+	}
+
+	if version == "" || name == "" {
+		typeVersion, typeName, ok := parseTypeName(fmt.Sprintf("%T", unwrapMigration(m)))
+		if ok && version == "" {
+			version = typeVersion
+		}
+		if ok && name == "" {
+			name = typeName
+		}
+	}
+
+	return version, name
+}
+
+// parseTypeName extracts the version and snake_case name from a type name
+// such as *migrations.Migration20240601000000CreateUsersTable
+func parseTypeName(typeName string) (version, name string, ok bool) {
+	match := typeNamePattern.FindStringSubmatch(typeName)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], camelToSnake(match[2]), true
+}
+
+// camelToSnake converts CreateUsersTable into create_users_table
+func camelToSnake(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// migrationKey is the identifier stored in the migration column and shown in
+// output, in the same <version>_<name> form as migration filenames
+func migrationKey(version, name string) string {
+	if version == "" || name == "" {
+		return version + name
+	}
+	return version + "_" + name
+}
+
+// identifyMigrations resolves the identity of every migration, rejecting
+// migrations without a version and versions used twice. The result is ordered
+// by version whatever order the source returned them in.
+func identifyMigrations(migrations []Migration) ([]*identifiedMigration, error) {
+	result := make([]*identifiedMigration, 0, len(migrations))
+	seen := make(map[string]string, len(migrations))
+
+	for _, migration := range migrations {
+		version, name := migrationID(migration)
+		if version == "" {
+			return nil, fmt.Errorf("migration %T has no version, register it with migration.Register or implement Version() and Name()", unwrapMigration(migration))
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %s", other, migrationKey(version, name), version)
+		}
+		seen[version] = migrationKey(version, name)
+
+		result = append(result, &identifiedMigration{
+			Migration: unwrapMigration(migration),
+			version:   version,
+			name:      name,
+			checksum:  migrationChecksum(migration),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	return result, nil
+}
+
+// identifyLegacyRecords fills in the version and name of records written by
+// older releases, which only stored the Go type name (fmt.Sprintf("%T")) of
+// the migration. Records nothing identifies keep an empty version.
+func identifyLegacyRecords(records []MigrationRecord, migrations []*identifiedMigration) {
+	// Records of migrations that are still loaded match their type name
+	byTypeName := make(map[string]*identifiedMigration, len(migrations))
+	for _, migration := range migrations {
+		byTypeName[fmt.Sprintf("%T", migration.Migration)] = migration
+	}
+
+	for i, record := range records {
+		if record.Version != "" {
+			continue
+		}
+
+		if migration, ok := byTypeName[record.Migration]; ok {
+			records[i].Version, records[i].Name = migration.version, migration.name
+		} else if version, name, ok := parseTypeName(record.Migration); ok {
+			records[i].Version, records[i].Name = version, name
+		}
+	}
+}
+
+// upgradeMigrationRecords stores the version and name of records written by
+// older releases. It runs once: upgraded records are not selected again.
+func upgradeMigrationRecords(db *gorm.DB, table string, migrations []*identifiedMigration) error {
+	var records []MigrationRecord
+	if err := db.Table(table).Where("version = ? OR version IS NULL", "").Find(&records).Error; err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	legacyNames := make([]string, len(records))
+	for i, record := range records {
+		legacyNames[i] = record.Migration
+	}
+	identifyLegacyRecords(records, migrations)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i, record := range records {
+			// Unidentified records are reported as missing
+			if record.Version == "" {
+				continue
+			}
+
+			err := tx.Table(table).Where("id = ?", record.ID).Updates(map[string]interface{}{
+				"migration": migrationKey(record.Version, record.Name),
+				"version":   record.Version,
+				"name":      record.Name,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to upgrade migration record %s: %w", legacyNames[i], err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,122 @@
+package migration
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestCamelToSnake(t *testing.T) {
+	tests := map[string]string{
+		"CreateUsersTable": "create_users_table",
+		"CreateAPIKeys":    "create_api_keys",
+		"AddOauth2ToUsers": "add_oauth2_to_users",
+		"HTTPServer":       "http_server",
+		"V2Users":          "v2_users",
+		"users":            "users",
+		"":                 "",
+	}
+	for in, want := range tests {
+		if got := camelToSnake(in); got != want {
+			t.Errorf("camelToSnake(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseTypeName(t *testing.T) {
+	tests := []struct {
+		typeName string
+		version  string
+		name     string
+		ok       bool
+	}{
+		{"*main.Migration20240601000000CreateUsersTable", "20240601000000", "create_users_table", true},
+		{"*migrations.Migration20240601000100CreateAPIKeys", "20240601000100", "create_api_keys", true},
+		{"main.Migration20240601000200AddOauth2ToUsers", "20240601000200", "add_oauth2_to_users", true},
+		{"*main.Migration20240601000300", "20240601000300", "", true},
+		{"*main.CreateUsersTable", "", "", false},
+		{"*main.MigrationCreateUsersTable", "", "", false},
+	}
+	for _, tt := range tests {
+		version, name, ok := parseTypeName(tt.typeName)
+		if version != tt.version || name != tt.name || ok != tt.ok {
+			t.Errorf("parseTypeName(%q) = %q, %q, %v, want %q, %q, %v", tt.typeName, version, name, ok, tt.version, tt.name, tt.ok)
+		}
+	}
+}
+
+// legacyMigration is a loaded migration whose records were written by an
+// older release under its Go type name
+type legacyMigration struct{}
+
+func (*legacyMigration) Up(*gorm.DB) error   { return nil }
+func (*legacyMigration) Down(*gorm.DB) error { return nil }
+
+func TestUpgradeMigrationRecords(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const table = "migration_records"
+	if err := ensureMigrationsTable(db, table); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, record := range []MigrationRecord{
+		// Matches a loaded migration by type name
+		{Migration: "*migration.legacyMigration", Batch: 1, CreatedAt: now},
+		// Matches no loaded migration, identified from its type name
+		{Migration: "*main.Migration20240601000100CreateAPIKeys", Batch: 1, CreatedAt: now},
+		{Migration: "*main.Migration20240601000200AddOauth2ToUsers", Batch: 2, CreatedAt: now},
+		// Nothing identifies it
+		{Migration: "*main.SeedUsers", Batch: 2, CreatedAt: now},
+		// Already upgraded
+		{Migration: "20240601000300_create_posts", Version: "20240601000300", Name: "create_posts", Batch: 3, CreatedAt: now},
+	} {
+		if err := db.Table(table).Create(&record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Older releases had no version column, so it can also be NULL
+	if err := db.Exec("UPDATE migration_records SET version = NULL WHERE migration = ?", "*main.Migration20240601000200AddOauth2ToUsers").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrations := []*identifiedMigration{
+		{Migration: &legacyMigration{}, version: "20240601000000", name: "create_users_table"},
+	}
+
+	want := []MigrationRecord{
+		{Migration: "20240601000000_create_users_table", Version: "20240601000000", Name: "create_users_table"},
+		{Migration: "20240601000100_create_api_keys", Version: "20240601000100", Name: "create_api_keys"},
+		{Migration: "20240601000200_add_oauth2_to_users", Version: "20240601000200", Name: "add_oauth2_to_users"},
+		{Migration: "*main.SeedUsers"},
+		{Migration: "20240601000300_create_posts", Version: "20240601000300", Name: "create_posts"},
+	}
+
+	// Upgrading again must leave the records as they are
+	for run := 1; run <= 2; run++ {
+		if err := upgradeMigrationRecords(db, table, migrations); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+
+		records, err := getMigrationRecords(db, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != len(want) {
+			t.Fatalf("run %d: got %d records, want %d", run, len(records), len(want))
+		}
+		for i, record := range records {
+			if record.Migration != want[i].Migration || record.Version != want[i].Version || record.Name != want[i].Name {
+				t.Errorf("run %d: record %d = %q, %q, %q, want %q, %q, %q", run, i,
+					record.Migration, record.Version, record.Name, want[i].Migration, want[i].Version, want[i].Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Migrator runs the migrations of one database. Migrators do not share state,
+// so several databases can be migrated in one process, for example from
+// parallel tests:
+//
+//	m := migration.New(
+//		migration.WithDB(db),
+//		migration.WithSource(migration.RegistrySource()),
+//	)
+//	err := m.Up()
+//
+// The package level functions use a default Migrator configured with
+// SetDatabaseConfig, SetDatabaseConnection and SetMigrationSource.
+type Migrator struct {
+	db      *gorm.DB
+	dialect string
+	dsn     string
+	source  Source
+	fsys    fs.FS
+	fsDir   string
+	logger  *slog.Logger
+	output  io.Writer
+
+	// dir holds the migrations, new ones are created in createDir and the
+	// plugin is built to pluginOutput, or cached in pluginCacheDir when empty
+	dir            string
+	createDir      string
+	pluginOutput   string
+	pluginCacheDir string
+	rebuildPlugin  bool
+
+	// plugin is a prebuilt migrations plugin loaded instead of compiling dir
+	plugin string
+
+	// seedersDir is where make:seeder creates seeders
+	seedersDir string
+
+	// table, tableSchema and tablePrefix make up the migrations table
+	table       string
+	tableSchema string
+	tablePrefix string
+
+	// conn is opened from dialect and dsn, copies of the Migrator share it
+	conn *connection
+}
+
+// connection is a database connection opened on first use
+type connection struct {
+	mu sync.Mutex
+	db *gorm.DB
+}
+
+// Option configures a Migrator created with New
+type Option func(*Migrator)
+
+// New returns a Migrator. Without options it stores its records in the
+// migration_records table, logs to slog.Default(), writes command output to
+// stdout and loads migrations the way ExecuteCommand does by default.
+func New(opts ...Option) *Migrator {
+	m := &Migrator{
+		dir:        defaultMigrationsDir,
+		seedersDir: defaultSeedersDir,
+		table:      defaultTableName,
+		logger:     slog.Default(),
+		output:     os.Stdout,
+		conn:       &connection{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// defaultMigrator is the Migrator used by the package level functions
+var defaultMigrator = New(WithDatabaseConfig("mysql", "user:password@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=True&loc=Local"))
+
+// WithDB makes the Migrator use an existing database connection
+func WithDB(db *gorm.DB) Option {
+	return func(m *Migrator) {
+		m.db = db
+	}
+}
+
+// WithDatabaseConfig makes the Migrator open its connection with the driver
+// registered for dialect, see SetDatabaseConfig
+func WithDatabaseConfig(dialect, dsn string) Option {
+	return func(m *Migrator) {
+		m.dialect = dialect
+		m.dsn = dsn
+	}
+}
+
+// WithSource sets the source migrations are loaded from, see SetMigrationSource
+func WithSource(src Source) Option {
+	return func(m *Migrator) {
+		m.source = src
+	}
+}
+
+// WithMigrationsFS sets where the default source reads SQL migration files
+// from, see SetMigrationsFS
+func WithMigrationsFS(fsys fs.FS, dir string) Option {
+	return func(m *Migrator) {
+		m.fsys = fsys
+		m.fsDir = dir
+	}
+}
+
+// WithMigrationsDir sets the directory migrations are loaded from, compiled
+// into a plugin and, unless WithCreateDir is used, created in. The default is
+// migrations in the working directory.
+func WithMigrationsDir(dir string) Option {
+	return func(m *Migrator) {
+		m.dir = dir
+	}
+}
+
+// WithCreateDir sets the directory make:migration writes new migrations to
+func WithCreateDir(dir string) Option {
+	return func(m *Migrator) {
+		m.createDir = dir
+	}
+}
+
+// WithSeedersDir sets the directory make:seeder writes new seeders to. The
+// default is seeders.
+func WithSeedersDir(dir string) Option {
+	return func(m *Migrator) {
+		m.seedersDir = dir
+	}
+}
+
+// WithPluginOutput sets where the migrations plugin is built. By default it is
+// cached outside the working directory, so it may be read-only. Setting an
+// output bypasses the cache and builds the plugin on every run.
+func WithPluginOutput(path string) Option {
+	return func(m *Migrator) {
+		m.pluginOutput = path
+	}
+}
+
+// WithPluginCacheDir sets the directory compiled migrations plugins are cached
+// in. The default is go-migration/plugins in the user cache directory.
+// Plugins unused for a week are pruned from it.
+func WithPluginCacheDir(dir string) Option {
+	return func(m *Migrator) {
+		m.pluginCacheDir = dir
+	}
+}
+
+// WithPluginRebuild compiles the migrations plugin even when a cached build
+// matches its sources
+func WithPluginRebuild() Option {
+	return func(m *Migrator) {
+		m.rebuildPlugin = true
+	}
+}
+
+// WithPlugin loads the migrations from a plugin built by migrate:build instead
+// of compiling the migrations directory, for hosts without a Go toolchain. The
+// plugin must be built with the Go version and module versions of the binary
+// loading it.
+func WithPlugin(path string) Option {
+	return func(m *Migrator) {
+		m.plugin = path
+	}
+}
+
+// WithTableName sets the table migration records are stored in, so services
+// sharing a database can keep separate histories. The default is
+// migration_records.
+func WithTableName(name string) Option {
+	return func(m *Migrator) {
+		m.table = name
+	}
+}
+
+// WithTableSchema puts the migrations table in a schema other than the
+// current one: a Postgres or SQL Server schema, which Postgres creates when
+// missing, a MySQL database or an attached SQLite database
+func WithTableSchema(schema string) Option {
+	return func(m *Migrator) {
+		m.tableSchema = schema
+	}
+}
+
+// WithTablePrefix prefixes the name of the migrations table, for example
+// "billing_" stores the records in billing_migration_records
+func WithTablePrefix(prefix string) Option {
+	return func(m *Migrator) {
+		m.tablePrefix = prefix
+	}
+}
+
+// WithLogger sets the logger progress is reported to. Events carry the
+// version, name and batch of the migration and, once it finished, its
+// duration or error. Diagnostics such as the plugin build are logged at debug
+// level. The default is slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Migrator) {
+		m.logger = logger
+	}
+}
+
+// WithOutput sets where command output, such as the status table and the SQL
+// of pretend mode, is written. The default is os.Stdout.
+func WithOutput(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.output = w
+	}
+}
+
+// Configure applies options to the Migrator used by the package level
+// functions and ExecuteCommand
+func Configure(opts ...Option) {
+	for _, opt := range opts {
+		opt(defaultMigrator)
+	}
+}
+
+// qualifiedTable returns name with the table prefix and schema of the
+// Migrator applied
+func (m *Migrator) qualifiedTable(name string) string {
+	table := m.tablePrefix + name
+	if m.tableSchema != "" {
+		table = m.tableSchema + "." + table
+	}
+	return table
+}
+
+// recordsTable returns the schema qualified name of the migrations table
+func (m *Migrator) recordsTable() string {
+	return m.qualifiedTable(m.table)
+}
+
+// lockName returns the name of the lock guarding the migrations table. The
+// default table keeps the name older releases used.
+func (m *Migrator) lockName() string {
+	if table := m.recordsTable(); table != defaultTableName {
+		return defaultLockName + ":" + table
+	}
+	return defaultLockName
+}
+
+// seedersTable returns the schema qualified name of the table run-once
+// seeders are recorded in, which shares the prefix of the migrations table
+func (m *Migrator) seedersTable() string {
+	return m.qualifiedTable(defaultSeedersTableName)
+}
+
+// DB returns the database connection of the Migrator, opening it on first use
+func (m *Migrator) DB() (*gorm.DB, error) {
+	if m.db != nil {
+		return m.db, nil
+	}
+
+	m.conn.mu.Lock()
+	defer m.conn.mu.Unlock()
+
+	if m.conn.db == nil {
+		if m.dialect == "" && m.dsn == "" {
+			return nil, errors.New("no database configured, use WithDB or WithDatabaseConfig")
+		}
+
+		db, err := openDatabase(m.dialect, m.dsn)
+		if err != nil {
+			return nil, err
+		}
+		m.conn.db = db
+	}
+	return m.conn.db, nil
+}
+
+// Migrations loads the migrations from the configured source. Without a
+// source, the plugin set with WithPlugin is loaded, or registered migrations
+// are used if there are any, otherwise the migrations directory is compiled
+// into a plugin. SQL files in the migrations directory, or the file system set
+// with WithMigrationsFS, are merged with either by version.
+func (m *Migrator) Migrations() ([]Migration, error) {
+	if m.source != nil {
+		return m.source.Load()
+	}
+
+	var sources []Source
+	if m.plugin != "" || (!hasRegisteredMigrations() && m.fsys == nil) {
+		sources = append(sources, SourceFunc(func() ([]Migration, error) {
+			return loadPluginMigrations(m.logger, m.pluginConfig())
+		}))
+	} else if hasRegisteredMigrations() {
+		sources = append(sources, RegistrySource())
+	}
+
+	fsys, dir := m.migrationsFS()
+	if hasSQLMigrations(fsys, dir) {
+		sources = append(sources, FSSource(fsys, dir))
+	}
+
+	return MultiSource(sources...).Load()
+}
+
+// pluginConfig returns where the plugin of the migrations directory is loaded
+// from and built
+func (m *Migrator) pluginConfig() pluginConfig {
+	return pluginConfig{
+		dir:      m.dir,
+		output:   m.pluginOutput,
+		cacheDir: m.pluginCacheDir,
+		rebuild:  m.rebuildPlugin,
+		plugin:   m.plugin,
+	}
+}
+
+// migrationsFS returns the file system and directory holding the SQL
+// migration files of the default source
+func (m *Migrator) migrationsFS() (fs.FS, string) {
+	if m.fsys != nil {
+		return m.fsys, m.fsDir
+	}
+	return os.DirFS(m.dir), "."
+}
+
+// withMigrations returns a copy of m that runs the given migrations against
+// db, used by the package level functions
+func (m *Migrator) withMigrations(db *gorm.DB, migrations []Migration) *Migrator {
+	c := *m
+	c.db = db
+	c.source = SourceFunc(func() ([]Migration, error) {
+		return migrations, nil
+	})
+	return &c
+}
+
+// withLogger returns a copy of m that logs to logger
+func (m *Migrator) withLogger(logger *slog.Logger) *Migrator {
+	c := *m
+	c.logger = logger
+	return &c
+}
+
+// run loads the migrations and calls fn with them while holding the lock
+func (m *Migrator) run(opts []RunOption, fn func(*gorm.DB, []Migration, *runConfig) error) error {
+	config := m.newRunConfig(opts)
+
+	db, err := m.DB()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	return withLock(db, config, func() error {
+		return fn(db.WithContext(config.ctx), migrations, config)
+	})
+}
+
+// Up runs every pending migration in a new batch, see RunMigrations
+func (m *Migrator) Up(opts ...RunOption) error {
+	return m.run(opts, runMigrations)
+}
+
+// Down rolls back the last batch of migrations, see RollbackMigrations
+func (m *Migrator) Down(opts ...RunOption) error {
+	return m.run(opts, rollbackMigrations)
+}
+
+// Reset rolls back every batch of migrations in reverse order
+func (m *Migrator) Reset(opts ...RunOption) error {
+	return m.run(opts, resetMigrations)
+}
+
+// Refresh rolls back every migration and runs them all again
+func (m *Migrator) Refresh(opts ...RunOption) error {
+	return m.run(opts, func(db *gorm.DB, migrations []Migration, config *runConfig) error {
+		if config.pretend {
+			return fmt.Errorf("pretend mode is not supported by refresh")
+		}
+		if err := resetMigrations(db, migrations, config); err != nil {
+			return err
+		}
+		return runMigrations(db, migrations, config)
+	})
+}
+
+// Fresh drops every table in the database, including tables that are not
+// managed by migrations, and runs all migrations from scratch
+func (m *Migrator) Fresh(opts ...RunOption) error {
+	return m.run(opts, func(db *gorm.DB, migrations []Migration, config *runConfig) error {
+		if config.pretend {
+			return fmt.Errorf("pretend mode is not supported by fresh")
+		}
+		if err := dropAllTables(db, config.logger, config.lockTable); err != nil {
+			return fmt.Errorf("failed to drop tables: %w", err)
+		}
+		if err := dropMigrationsTable(db, config.table); err != nil {
+			return fmt.Errorf("failed to drop migrations table: %w", err)
+		}
+		return runMigrations(db, migrations, config)
+	})
+}
+
+// Repair stores the current checksum of every applied migration, see
+// RepairChecksums
+func (m *Migrator) Repair(opts ...RunOption) error {
+	return m.run(opts, repairChecksums)
+}
+
+// Status returns the state of every migration, see Status
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	db, err := m.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	return migrationStatus(db, m.recordsTable(), migrations)
+}
+
+// Create creates a new migration file in the directory set with WithCreateDir,
+// or the migrations directory
+func (m *Migrator) Create(name string) error {
+	dir := m.createDir
+	if dir == "" {
+		dir = m.dir
+	}
+	return createMigration(m.logger, dir, name)
+}
+
+// Seed runs the registered seeders in registration order while holding the
+// lock. WithSeederClass runs a single seeder and WithForceSeed runs run-once
+// seeders again.
+func (m *Migrator) Seed(opts ...RunOption) error {
+	config := m.newRunConfig(opts)
+
+	db, err := m.DB()
+	if err != nil {
+		return err
+	}
+
+	return withLock(db, config, func() error {
+		return runSeeders(db.WithContext(config.ctx), config)
+	})
+}
+
+// CreateSeeder creates a new seeder file in the directory set with
+// WithSeedersDir
+func (m *Migrator) CreateSeeder(name string) error {
+	return createSeeder(m.logger, m.seedersDir, name)
+}
+
+// Build compiles the migrations directory into a plugin at output, bypassing
+// the cache. The plugin can then be loaded with WithPlugin on hosts without a
+// Go toolchain.
+func (m *Migrator) Build(output string) error {
+	migrationsPath, filenames, err := pluginSources(m.logger, m.dir)
+	if err != nil {
+		return err
+	}
+	if len(filenames) == 0 {
+		return fmt.Errorf("no Go migrations found in %s", migrationsPath)
+	}
+
+	path, err := filepath.Abs(output)
+	if err != nil {
+		return fmt.Errorf("failed to resolve plugin output path: %w", err)
+	}
+	if err := compilePlugin(m.logger, migrationsPath, filenames, path); err != nil {
+		return err
+	}
+
+	// The binary building the plugin is usually the one deploying it
+	if err := checkPluginCompatibility(path); err != nil {
+		m.logger.Warn("built plugin cannot be loaded by this binary", "path", path, "error", err)
+	}
+	m.logger.Info("built migrations plugin", "path", path, "migrations", len(filenames))
+	return nil
+}
@@ -0,0 +1,531 @@
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Locker guards migrations against being run by several processes at once,
+// for example when every replica of a service migrates on startup. The lock is
+// acquired around a whole run or rollback.
+type Locker interface {
+	// Lock blocks until the lock is acquired or ctx is done
+	Lock(ctx context.Context) error
+	// Unlock releases a lock acquired with Lock
+	Unlock(ctx context.Context) error
+}
+
+// ErrLockTimeout is returned when the migration lock could not be acquired
+// before the lock timeout expired
+var ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// ErrLockLost is returned when the migration lock was lost during a run, for
+// example when the lease of a TableLocker expired and another process took it
+var ErrLockLost = errors.New("migration lock lost")
+
+// lossNotifier is implemented by lockers that can lose a lock they hold
+type lossNotifier interface {
+	// lockLost is closed once the lock held is lost
+	lockLost() <-chan struct{}
+}
+
+const (
+	// defaultLockName is the name of the lock used by DefaultLocker
+	defaultLockName = "go-migration"
+	// defaultLockTimeout is how long to wait for the lock unless WithLockTimeout is used
+	defaultLockTimeout = 5 * time.Minute
+	// lockPollInterval is how often lockers that cannot block retry
+	lockPollInterval = 500 * time.Millisecond
+	// lockTableName is the table used by the table locker
+	lockTableName = "migration_locks"
+)
+
+// DefaultLocker returns the locker best suited to the database: GET_LOCK on
+// MySQL, advisory locks on Postgres, and a lease row in the migration_locks
+// table on every other database
+func DefaultLocker(db *gorm.DB) Locker {
+	return newDefaultLocker(db, defaultLockName, lockTableName, nil)
+}
+
+// newDefaultLocker returns the locker DefaultLocker would, with the lock name,
+// lock table and logger of a run
+func newDefaultLocker(db *gorm.DB, name, table string, logger *slog.Logger) Locker {
+	switch db.Dialector.Name() {
+	case "mysql":
+		return NewMySQLLocker(db, name)
+	case "postgres":
+		return NewPostgresLocker(db, name)
+	default:
+		locker := NewTableLocker(db, name)
+		locker.table = table
+		locker.Logger = logger
+		return locker
+	}
+}
+
+// withLock runs fn while holding the configured lock, or the default lock of
+// the migrations table. Pretend runs do not change anything and never wait
+// for the lock.
+func withLock(db *gorm.DB, config *runConfig, fn func() error) (err error) {
+	if config.noLock || config.pretend {
+		return fn()
+	}
+	locker := config.locker
+	if locker == nil {
+		locker = newDefaultLocker(db, config.lockName, config.lockTable, config.logger)
+	}
+
+	ctx := config.ctx
+	if config.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.lockTimeout)
+		defer cancel()
+	}
+
+	if err := locker.Lock(ctx); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	defer func() {
+		// The lock wait may have used up ctx, release with a fresh one
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if unlockErr := locker.Unlock(unlockCtx); unlockErr != nil && err == nil {
+			err = fmt.Errorf("failed to release migration lock: %w", unlockErr)
+		}
+	}()
+
+	notifier, ok := locker.(lossNotifier)
+	if !ok {
+		return fn()
+	}
+
+	// Another process may run migrations once the lock is lost, so the run
+	// is cancelled before its next migration
+	runCtx, cancel := context.WithCancel(config.ctx)
+	defer cancel()
+	lost := notifier.lockLost()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	parent := config.ctx
+	config.ctx = runCtx
+	defer func() { config.ctx = parent }()
+
+	err = fn()
+	select {
+	case <-lost:
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrLockLost, err)
+		}
+		return ErrLockLost
+	default:
+		return err
+	}
+}
+
+// lockTimeoutError converts a context error from waiting on a lock
+func lockTimeoutError(ctx context.Context, name string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w %q", ErrLockTimeout, name)
+	}
+	return ctx.Err()
+}
+
+// sessionLocker holds a connection-scoped lock on a dedicated connection.
+// MySQL named locks and Postgres advisory locks belong to the session that
+// took them, so the same connection must be used to release them.
+type sessionLocker struct {
+	mu   sync.Mutex
+	db   *gorm.DB
+	name string
+	conn *sql.Conn
+}
+
+// acquireConn reserves a connection from the pool for the lock
+func (l *sessionLocker) acquireConn(ctx context.Context) (*sql.Conn, error) {
+	if l.conn != nil {
+		return nil, fmt.Errorf("migration lock %q is already held", l.name)
+	}
+
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return sqlDB.Conn(ctx)
+}
+
+// releaseConn returns the lock connection to the pool
+func (l *sessionLocker) releaseConn() error {
+	if l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+// mysqlLocker uses MySQL named locks (GET_LOCK / RELEASE_LOCK)
+type mysqlLocker struct {
+	sessionLocker
+}
+
+// mysqlMaxLockName is the longest lock name GET_LOCK accepts
+const mysqlMaxLockName = 64
+
+// NewMySQLLocker returns a Locker backed by MySQL GET_LOCK. Names longer than
+// GET_LOCK accepts are shortened with a hash. The lock is released
+// automatically by MySQL if the process dies.
+func NewMySQLLocker(db *gorm.DB, name string) Locker {
+	return &mysqlLocker{sessionLocker{db: db, name: mysqlLockName(name)}}
+}
+
+// mysqlLockName keeps the start of a long name for readability and replaces
+// the rest with a hash of the whole name, so distinct names stay distinct
+func mysqlLockName(name string) string {
+	if len(name) <= mysqlMaxLockName {
+		return name
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	suffix := fmt.Sprintf(":%016x", hash.Sum64())
+
+	end := mysqlMaxLockName - len(suffix)
+	for end > 0 && !utf8.RuneStart(name[end]) {
+		end--
+	}
+	return name[:end] + suffix
+}
+
+func (l *mysqlLocker) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conn, err := l.acquireConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// GET_LOCK waits by itself, a negative timeout waits forever
+	timeout := -1
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = int(math.Ceil(time.Until(deadline).Seconds()))
+		if timeout < 0 {
+			timeout = 0
+		}
+	}
+
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", l.name, timeout).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return lockTimeoutError(ctx, l.name)
+		}
+		return fmt.Errorf("failed to get lock %q: %w", l.name, err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return fmt.Errorf("%w %q", ErrLockTimeout, l.name)
+	}
+
+	l.conn = conn
+	return nil
+}
+
+func (l *mysqlLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+
+	_, err := l.conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", l.name)
+	if closeErr := l.releaseConn(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// postgresLocker uses Postgres session-level advisory locks
+type postgresLocker struct {
+	sessionLocker
+	key int64
+}
+
+// NewPostgresLocker returns a Locker backed by pg_advisory_lock. The name is
+// hashed into the 64-bit advisory lock key. The lock is released automatically
+// by Postgres if the process dies.
+func NewPostgresLocker(db *gorm.DB, name string) Locker {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+
+	return &postgresLocker{
+		sessionLocker: sessionLocker{db: db, name: name},
+		key:           int64(hash.Sum64()),
+	}
+}
+
+func (l *postgresLocker) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conn, err := l.acquireConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// pg_advisory_lock cannot time out by itself, so poll with the non
+	// blocking variant until ctx is done
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired)
+		if err != nil {
+			conn.Close()
+			if ctx.Err() != nil {
+				return lockTimeoutError(ctx, l.name)
+			}
+			return fmt.Errorf("failed to get advisory lock %q: %w", l.name, err)
+		}
+		if acquired {
+			l.conn = conn
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return lockTimeoutError(ctx, l.name)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *postgresLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	if closeErr := l.releaseConn(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// MigrationLock is a lease row of the table locker
+type MigrationLock struct {
+	Name      string    `gorm:"primaryKey;size:255"`
+	Owner     string    `gorm:"size:255;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// TableName keeps the lock table name in one place
+func (MigrationLock) TableName() string {
+	return lockTableName
+}
+
+// TableLocker is a portable Locker that stores a lease row in the
+// migration_locks table, or the table of the Migrator using it. While the
+// lock is held a heartbeat extends the lease, so a lock left behind by a
+// crashed process expires after Lease. A run whose lease could not be
+// extended is cancelled with ErrLockLost.
+type TableLocker struct {
+	// Lease is how long the lock stays valid without a heartbeat
+	Lease time.Duration
+	// Logger receives the warnings of the heartbeat, slog.Default() when nil
+	Logger *slog.Logger
+
+	db    *gorm.DB
+	table string
+	name  string
+	owner string
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+}
+
+// NewTableLocker returns a TableLocker with a 30 second lease
+func NewTableLocker(db *gorm.DB, name string) *TableLocker {
+	return &TableLocker{
+		Lease: 30 * time.Second,
+		db:    db,
+		table: lockTableName,
+		name:  name,
+		owner: lockOwner(),
+	}
+}
+
+// lockOwner identifies this process in the lock table
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func (l *TableLocker) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stop != nil {
+		return fmt.Errorf("migration lock %q is already held", l.name)
+	}
+
+	if l.Lease <= 0 {
+		l.Lease = 30 * time.Second
+	}
+
+	if err := ensureLocksTable(l.db.WithContext(ctx), l.table); err != nil {
+		return fmt.Errorf("failed to create lock table: %w", err)
+	}
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := l.tryLock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return lockTimeoutError(ctx, l.name)
+			}
+			return fmt.Errorf("failed to get lock %q: %w", l.name, err)
+		}
+		if acquired {
+			l.stop = make(chan struct{})
+			l.done = make(chan struct{})
+			l.lost = make(chan struct{})
+			go l.heartbeat(l.stop, l.done, l.lost)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return lockTimeoutError(ctx, l.name)
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryLock inserts the lease row, or takes it over when it has expired
+func (l *TableLocker) tryLock(ctx context.Context) (bool, error) {
+	db := l.db.WithContext(ctx)
+	now := time.Now()
+
+	result := insertInto(db, l.table).Clauses(clause.OnConflict{DoNothing: true}).Create(&MigrationLock{
+		Name:      l.name,
+		Owner:     l.owner,
+		ExpiresAt: now.Add(l.Lease),
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	result = db.Table(l.table).
+		Where("name = ? AND expires_at < ?", l.name, now).
+		Updates(map[string]interface{}{"owner": l.owner, "expires_at": now.Add(l.Lease)})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// heartbeat extends the lease until stop is closed. It closes lost once the
+// lease row belongs to another process, or could not be extended before the
+// lease expired.
+func (l *TableLocker) heartbeat(stop <-chan struct{}, done, lost chan<- struct{}) {
+	defer close(done)
+
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	ticker := time.NewTicker(l.Lease / 3)
+	defer ticker.Stop()
+
+	extended := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			result := l.db.Table(l.table).
+				Where("name = ? AND owner = ?", l.name, l.owner).
+				Update("expires_at", now.Add(l.Lease))
+			switch {
+			case result.Error != nil:
+				logger.Warn("failed to extend migration lock", "lock", l.name, "error", result.Error)
+				if now.Sub(extended) >= l.Lease {
+					logger.Warn("migration lock lease expired, cancelling the run", "lock", l.name)
+					close(lost)
+					return
+				}
+			case result.RowsAffected == 0:
+				logger.Warn("migration lock was taken over by another process, cancelling the run", "lock", l.name)
+				close(lost)
+				return
+			default:
+				extended = now
+			}
+		}
+	}
+}
+
+// lockLost is closed once the lock held is lost
+func (l *TableLocker) lockLost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+func (l *TableLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stop == nil {
+		return nil
+	}
+
+	close(l.stop)
+	<-l.done
+	l.stop, l.done, l.lost = nil, nil, nil
+
+	return l.db.WithContext(ctx).Table(l.table).
+		Where("name = ? AND owner = ?", l.name, l.owner).
+		Delete(&MigrationLock{}).Error
+}
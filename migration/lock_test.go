@@ -0,0 +1,28 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMySQLLockName(t *testing.T) {
+	if got := mysqlLockName("go-migration:app.schema_migrations"); got != "go-migration:app.schema_migrations" {
+		t.Errorf("short name changed to %q", got)
+	}
+
+	long := "go-migration:" + strings.Repeat("tenant_", 10) + "migrations"
+	other := "go-migration:" + strings.Repeat("tenant_", 10) + "migration_records"
+	for _, name := range []string{long, other, "go-migration:" + strings.Repeat("é", 40)} {
+		got := mysqlLockName(name)
+		if len(got) > mysqlMaxLockName || !utf8.ValidString(got) {
+			t.Errorf("mysqlLockName(%q) = %q, want at most %d bytes of valid UTF-8", name, got, mysqlMaxLockName)
+		}
+		if !strings.HasPrefix(got, "go-migration:") {
+			t.Errorf("mysqlLockName(%q) = %q, want the start of the name kept", name, got)
+		}
+	}
+	if mysqlLockName(long) == mysqlLockName(other) {
+		t.Errorf("names sharing a long prefix got the same lock name %q", mysqlLockName(long))
+	}
+}
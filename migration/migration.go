@@ -1,11 +1,16 @@
 package migration
 
-const migrationTemplate = `package main
+const migrationTemplate = `package migrations
 
 import (
+	"github.com/tensuqiuwulu/go-migration/migration"
 	"gorm.io/gorm"
 )
 
+func init() {
+	migration.Register("{{.Version}}", "{{.Name}}", &{{.StructName}}{})
+}
+
 type {{.StructName}} struct {}
 
 func (m *{{.StructName}}) Up(db *gorm.DB) error {
@@ -17,7 +22,49 @@ func (m *{{.StructName}}) Down(db *gorm.DB) error {
 	// Implement your rollback here
 	return nil
 }
+`
+
+// pluginMigrationTemplate is used instead of migrationTemplate in a
+// migrations directory of package main, which is compiled as a plugin
+const pluginMigrationTemplate = `package main
+
+import (
+	"gorm.io/gorm"
+)
+
+type {{.StructName}} struct {}
+
+func (m *{{.StructName}}) Up(db *gorm.DB) error {
+	// Implement your migration here
+	return nil
+}
+
+func (m *{{.StructName}}) Down(db *gorm.DB) error {
+	// Implement your rollback here
+	return nil
+}
+`
+
+const seederTemplate = `package seeders
+
+import (
+	"github.com/tensuqiuwulu/go-migration/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterSeeder("{{.StructName}}", &{{.StructName}}{})
+}
+
+type {{.StructName}} struct {}
+
+func (s *{{.StructName}}) Run(db *gorm.DB) error {
+	// Insert your seed data here
+	return nil
+}
 
-// Ekspor struct migrasi untuk sistem plugin
-var {{.StructName}}_Exported = &{{.StructName}}{}
-`
\ No newline at end of file
+// Uncomment to record the seeder and never apply it twice to a database
+// func (s *{{.StructName}}) RunOnce() bool {
+// 	return true
+// }
+`
@@ -1,9 +1,12 @@
 package migration
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -15,8 +18,13 @@ import (
 
 // MigrationRecord represents a record in the migrations table
 type MigrationRecord struct {
-	ID        uint      `gorm:"primaryKey"`
-	Migration string    `gorm:"size:255;not null;unique"`
+	ID uint `gorm:"primaryKey"`
+	// Migration holds <version>_<name>, older releases stored the Go type name
+	Migration string `gorm:"size:255;not null;unique"`
+	Version   string `gorm:"size:255;index"`
+	Name      string `gorm:"size:255"`
+	// Checksum of the migration source when it was applied
+	Checksum  string    `gorm:"size:64"`
 	Batch     int       `gorm:"not null"`
 	CreatedAt time.Time `gorm:"not null"`
 }
@@ -26,17 +34,30 @@ type Migration interface {
 	Down(*gorm.DB) error
 }
 
+// NoTransactionMigration can be implemented by migrations that cannot run
+// inside a transaction, such as Postgres CREATE INDEX CONCURRENTLY. When
+// NoTransaction returns true the migration and its record are applied
+// without a wrapping transaction.
+type NoTransactionMigration interface {
+	NoTransaction() bool
+}
+
 // CreateMigration membuat file migration baru
 func CreateMigration(name string) error {
+	return defaultMigrator.Create(name)
+}
+
+// createMigration membuat file migration baru di direktori dir
+func createMigration(logger *slog.Logger, dir, name string) error {
 	// Membuat direktori migrations jika belum ada
-	if err := os.MkdirAll("migrations", os.ModePerm); err != nil {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create migrations directory: %w", err)
 	}
 
 	// Generate timestamp untuk nama file
 	timestamp := time.Now().Format("20060102150405")
 	filename := fmt.Sprintf("%s_%s.go", timestamp, snakeCase(name))
-	filePath := filepath.Join("migrations", filename)
+	filePath := filepath.Join(dir, filename)
 
 	// Membuat file migration
 	file, err := os.Create(filePath)
@@ -48,23 +69,33 @@ func CreateMigration(name string) error {
 	// Generate nama struct untuk migration
 	structName := fmt.Sprintf("Migration%s%s", timestamp, camelCase(name))
 
+	// Direktori plugin lama (package main) tidak memakai migration.Register
+	source := migrationTemplate
+	if goPackageName(dir) == "main" {
+		source = pluginMigrationTemplate
+	}
+
 	// Eksekusi template
-	tmpl, err := template.New("migration").Parse(migrationTemplate)
+	tmpl, err := template.New("migration").Parse(source)
 	if err != nil {
 		return fmt.Errorf("failed to parse migration template: %w", err)
 	}
 
 	data := struct {
 		StructName string
+		Version    string
+		Name       string
 	}{
 		StructName: structName,
+		Version:    timestamp,
+		Name:       snakeCase(name),
 	}
 
 	if err := tmpl.Execute(file, data); err != nil {
 		return fmt.Errorf("failed to generate migration content: %w", err)
 	}
 
-	fmt.Printf("Created new migration: %s\n", filePath)
+	logger.Info("created migration", "path", filePath)
 	return nil
 }
 
@@ -82,164 +113,570 @@ func camelCase(s string) string {
 	}
 	return strings.Join(words, "")
 }
-// ensureMigrationsTable ensures that the migrations table exists
-func ensureMigrationsTable(db *gorm.DB) error {
-	return db.AutoMigrate(&MigrationRecord{})
-}
+
+// defaultMigrationsDir is the directory migrations are loaded from and created
+// in unless WithMigrationsDir is used
+const defaultMigrationsDir = "migrations"
+
+// defaultTableName is the table migration records are stored in unless
+// WithTableName is used
+const defaultTableName = "migration_records"
 
 // getMigrationBatch gets the current batch number
-func getMigrationBatch(db *gorm.DB) (int, error) {
+func getMigrationBatch(db *gorm.DB, table string) (int, error) {
 	var batch int
-	result := db.Model(&MigrationRecord{}).Select("COALESCE(MAX(batch), 0) + 1").Scan(&batch)
+	result := db.Table(table).Select("COALESCE(MAX(batch), 0) + 1").Scan(&batch)
 	if result.Error != nil {
 		return 0, result.Error
 	}
 	return batch, nil
 }
 
-// getMigratedNames gets the names of migrations that have already been run
-func getMigratedNames(db *gorm.DB) (map[string]bool, error) {
+// getMigrationRecords gets every migration record in the order they were run
+func getMigrationRecords(db *gorm.DB, table string) ([]MigrationRecord, error) {
 	var records []MigrationRecord
-	result := db.Find(&records)
+	result := db.Table(table).Order("id").Find(&records)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	return records, nil
+}
 
-	migratedNames := make(map[string]bool)
-	for _, record := range records {
-		migratedNames[record.Migration] = true
+// getAppliedRecords gets the migration records for a run. The records of
+// older releases are upgraded first, or only identified in memory when the
+// table must not be changed (pretend mode, status).
+func getAppliedRecords(db *gorm.DB, table string, migrations []*identifiedMigration, readOnly bool) ([]MigrationRecord, error) {
+	if readOnly {
+		exists, err := hasTable(db, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check migrations table: %w", err)
+		}
+		if !exists {
+			return nil, nil
+		}
+	} else {
+		// Ensure migrations table exists
+		if err := ensureMigrationsTable(db, table); err != nil {
+			return nil, fmt.Errorf("failed to create migrations table: %w", err)
+		}
+		if err := upgradeMigrationRecords(db, table, migrations); err != nil {
+			return nil, fmt.Errorf("failed to upgrade migration records: %w", err)
+		}
 	}
 
-	return migratedNames, nil
+	records, err := getMigrationRecords(db, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration records: %w", err)
+	}
+
+	if readOnly {
+		identifyLegacyRecords(records, migrations)
+	}
+	return records, nil
 }
 
 // recordMigration records that a migration has been run
-func recordMigration(db *gorm.DB, name string, batch int) error {
-	return db.Create(&MigrationRecord{
-		Migration: name,
+func recordMigration(db *gorm.DB, table string, migration *identifiedMigration, batch int) error {
+	return insertInto(db, table).Create(&MigrationRecord{
+		Migration: migration.key(),
+		Version:   migration.version,
+		Name:      migration.name,
+		Checksum:  migration.checksum,
 		Batch:     batch,
 		CreatedAt: time.Now(),
 	}).Error
 }
 
-// getLastBatchMigrations gets the migrations from the last batch
-func getLastBatchMigrations(db *gorm.DB) ([]string, error) {
-	var lastBatch int
-	result := db.Model(&MigrationRecord{}).Select("MAX(batch)").Scan(&lastBatch)
-	if result.Error != nil {
-		return nil, result.Error
+// lastBatchRecords returns the records of the last batch in reverse order
+func lastBatchRecords(records []MigrationRecord) []MigrationRecord {
+	lastBatch := 0
+	for _, record := range records {
+		if record.Batch > lastBatch {
+			lastBatch = record.Batch
+		}
 	}
+	return batchRecords(records, lastBatch)
+}
 
-	var records []MigrationRecord
-	result = db.Where("batch = ?", lastBatch).Order("id DESC").Find(&records)
-	if result.Error != nil {
-		return nil, result.Error
+// batchRecords returns the records of a batch in reverse order
+func batchRecords(records []MigrationRecord, batch int) []MigrationRecord {
+	var result []MigrationRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Batch == batch {
+			result = append(result, records[i])
+		}
 	}
+	return result
+}
 
-	migrations := make([]string, len(records))
+// reversedRecords returns every record, from the last batch to the first and
+// in reverse order within each batch
+func reversedRecords(records []MigrationRecord) []MigrationRecord {
+	result := make([]MigrationRecord, len(records))
 	for i, record := range records {
-		migrations[i] = record.Migration
+		result[len(records)-1-i] = record
 	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Batch > result[j].Batch
+	})
+	return result
+}
 
-	return migrations, nil
+// recordsAfter returns the records of migrations newer than version, newest
+// version first regardless of their batch
+func recordsAfter(records []MigrationRecord, version string) []MigrationRecord {
+	var result []MigrationRecord
+	for _, record := range reversedRecords(records) {
+		if record.Version > version {
+			result = append(result, record)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Version > result[j].Version
+	})
+	return result
+}
+
+// checkTarget fails when a target version is set that no migration has
+func checkTarget(migrations []*identifiedMigration, target string) error {
+	if target == "" {
+		return nil
+	}
+	for _, migration := range migrations {
+		if migration.version == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: target version %s does not match any migration", ErrUsage, target)
 }
 
 // removeMigrationRecord removes a migration record
-func removeMigrationRecord(db *gorm.DB, name string) error {
-	return db.Where("migration = ?", name).Delete(&MigrationRecord{}).Error
+func removeMigrationRecord(db *gorm.DB, table string, record MigrationRecord) error {
+	return db.Table(table).Where("id = ?", record.ID).Delete(&MigrationRecord{}).Error
 }
 
-func RunMigrations(db *gorm.DB, migrations []Migration) error {
-	// Ensure migrations table exists
-	if err := ensureMigrationsTable(db); err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+// inTransaction runs fn inside a transaction unless the migration opted out
+// by implementing NoTransactionMigration
+func inTransaction(db *gorm.DB, config *runConfig, migration Migration, fn func(*gorm.DB) error) error {
+	if !usesTransaction(migration) {
+		return fn(db)
 	}
+	return transaction(db, config, fn)
+}
 
-	// Get current batch number
-	batch, err := getMigrationBatch(db)
+// transaction runs fn inside a transaction that is not bound to the context
+// of the run. database/sql rolls a transaction back as soon as the context it
+// began with is done, which would drop the record of a migration that already
+// completed, and on databases without transactional DDL leave its changes
+// unrecorded. Only Up and Down get the cancellable context.
+func transaction(db *gorm.DB, config *runConfig, fn func(*gorm.DB) error) error {
+	return db.WithContext(context.WithoutCancel(config.ctx)).Transaction(fn)
+}
+
+// usesTransaction reports whether a migration may run inside a transaction
+func usesTransaction(migration Migration) bool {
+	noTx, ok := unwrapMigration(migration).(NoTransactionMigration)
+	return !ok || !noTx.NoTransaction()
+}
+
+// requireTransactions returns an error if one of the migrations opted out of
+// transactions, which the single transaction mode cannot honour
+func requireTransactions(migrations []*identifiedMigration) error {
+	for _, migration := range migrations {
+		if !usesTransaction(migration) {
+			return fmt.Errorf("migration %s cannot run inside a transaction, run it without the single transaction mode", migration.key())
+		}
+	}
+	return nil
+}
+
+// runMigration runs a migration and records it in the given batch
+func runMigration(db *gorm.DB, config *runConfig, migration *identifiedMigration, batch int) error {
+	migrationName := migration.key()
+	logger := config.logger.With("version", migration.version, "name", migration.name, "batch", batch)
+
+	logger.Info("running migration")
+	start := time.Now()
+
+	// Run migration
+	ctx, cancel := migrationContext(config, migration)
+	defer cancel()
+	if err := callUp(ctx, db, migration); err != nil {
+		err = migrationError(ctx, config, "run", migration, err)
+		logger.Error("migration failed", "duration", time.Since(start), "error", err)
+		return err
+	}
+
+	// Record the migration even if the run was interrupted once Up returned,
+	// the transaction it runs in is not cancelled either
+	db = db.WithContext(context.WithoutCancel(config.ctx))
+	if err := recordMigration(db, config.table, migration, batch); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", migrationName, err)
+	}
+
+	logger.Info("migration completed", "duration", time.Since(start))
+	return nil
+}
+
+// rollbackMigration reverts a migration and removes its record
+func rollbackMigration(db *gorm.DB, config *runConfig, migration *identifiedMigration, record MigrationRecord) error {
+	migrationName := migration.key()
+	logger := config.logger.With("version", migration.version, "name", migration.name, "batch", record.Batch)
+
+	logger.Info("rolling back migration")
+	start := time.Now()
+
+	// Run down migration
+	ctx, cancel := migrationContext(config, migration)
+	defer cancel()
+	if err := callDown(ctx, db, migration); err != nil {
+		err = migrationError(ctx, config, "rollback", migration, err)
+		logger.Error("rollback failed", "duration", time.Since(start), "error", err)
+		return err
+	}
+
+	// Remove the record even if the run was interrupted once Down returned,
+	// the transaction it runs in is not cancelled either
+	db = db.WithContext(context.WithoutCancel(config.ctx))
+	if err := removeMigrationRecord(db, config.table, record); err != nil {
+		return fmt.Errorf("failed to remove migration record %s: %w", migrationName, err)
+	}
+
+	logger.Info("rolled back migration", "duration", time.Since(start))
+	return nil
+}
+
+// RunMigrations runs every pending migration in a new batch. Each migration and
+// its record are applied inside one transaction, unless the migration
+// implements NoTransactionMigration.
+func RunMigrations(db *gorm.DB, migrations []Migration, opts ...RunOption) error {
+	return defaultMigrator.withMigrations(db, migrations).Up(opts...)
+}
+
+// runMigrations runs every pending migration in a new batch
+func runMigrations(db *gorm.DB, migrations []Migration, config *runConfig) error {
+	// A policy mistyped through the API must not weaken the default
+	policy, err := parseOutOfOrderPolicy(string(config.outOfOrder))
 	if err != nil {
-		return fmt.Errorf("failed to get migration batch: %w", err)
+		return fmt.Errorf("%w: %v", ErrUsage, err)
 	}
 
-	// Get already migrated names
-	migratedNames, err := getMigratedNames(db)
+	identifiedMigrations, err := identifyMigrations(migrations)
 	if err != nil {
-		return fmt.Errorf("failed to get migrated names: %w", err)
+		return err
 	}
 
-	// Run pending migrations
-	for _, migration := range migrations {
-		// Get migration name from type
-		migrationName := fmt.Sprintf("%T", migration)
+	// Get already applied migrations
+	records, err := getAppliedRecords(db, config.table, identifiedMigrations, config.pretend)
+	if err != nil {
+		return err
+	}
+	applied := make(map[string]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
 
+	if err := checkMissing(config.logger, identifiedMigrations, records, config.ignoreMissing); err != nil {
+		return err
+	}
+
+	if err := checkChecksums(config.logger, identifiedMigrations, records, config.strictChecksums); err != nil {
+		return err
+	}
+
+	if err := checkTarget(identifiedMigrations, config.target); err != nil {
+		return err
+	}
+
+	// Collect pending migrations
+	pending := make([]*identifiedMigration, 0, len(identifiedMigrations))
+	for _, migration := range identifiedMigrations {
 		// Skip if already migrated
-		if migratedNames[migrationName] {
-			fmt.Printf("Skipping migration %s (already run)\n", migrationName)
+		if applied[migration.version] {
+			if !config.pretend {
+				config.logger.Debug("skipping migration, already run", "version", migration.version, "name", migration.name)
+			}
 			continue
 		}
+		// Stop at the target version, identifyMigrations orders by version
+		if config.target != "" && migration.version > config.target {
+			break
+		}
 
-		fmt.Printf("Running migration %s...\n", migrationName)
+		pending = append(pending, migration)
+	}
 
-		// Run migration
-		if err := migration.Up(db); err != nil {
-			return fmt.Errorf("failed to run migration %s: %w", migrationName, err)
-		}
+	if err := checkOutOfOrder(config.logger, identifiedMigrations, pending, applied, policy); err != nil {
+		return err
+	}
 
-		// Record migration
-		if err := recordMigration(db, migrationName, batch); err != nil {
-			return fmt.Errorf("failed to record migration %s: %w", migrationName, err)
+	if config.pretend {
+		return pretendMigrations(db, config, pending)
+	}
+
+	// Get current batch number
+	batch, err := getMigrationBatch(db, config.table)
+	if err != nil {
+		return fmt.Errorf("failed to get migration batch: %w", err)
+	}
+
+	if config.singleTransaction {
+		if err := requireTransactions(pending); err != nil {
+			return err
 		}
 
-		fmt.Printf("Migration %s completed\n", migrationName)
+		return transaction(db, config, func(tx *gorm.DB) error {
+			for _, migration := range pending {
+				if err := checkInterrupted(config, "run", migration); err != nil {
+					return err
+				}
+				if err := runMigration(tx, config, migration, batch); err != nil {
+					return interruptedError(config, "run", migration, err)
+				}
+			}
+			return nil
+		})
+	}
+
+	// Run pending migrations
+	for _, migration := range pending {
+		if err := checkInterrupted(config, "run", migration); err != nil {
+			return err
+		}
+		err := inTransaction(db, config, migration, func(tx *gorm.DB) error {
+			return runMigration(tx, config, migration, batch)
+		})
+		if err != nil {
+			return interruptedError(config, "run", migration, err)
+		}
 	}
 
 	return nil
 }
 
-func RollbackMigrations(db *gorm.DB, migrations []Migration) error {
-	// Ensure migrations table exists
-	if err := ensureMigrationsTable(db); err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+// RollbackMigrations rolls back the last batch of migrations. WithStep rolls
+// back a number of individual migrations instead and WithBatch a specific
+// batch. Like RunMigrations, each migration is reverted inside its own
+// transaction.
+func RollbackMigrations(db *gorm.DB, migrations []Migration, opts ...RunOption) error {
+	return defaultMigrator.withMigrations(db, migrations).Down(opts...)
+}
+
+// rollbackMigrations rolls back the migrations selected by the configuration
+func rollbackMigrations(db *gorm.DB, migrations []Migration, config *runConfig) error {
+	// Zero means unset, anything below would silently roll back the last batch
+	if config.step < 0 {
+		return fmt.Errorf("%w: step must not be negative, got %d", ErrUsage, config.step)
+	}
+	if config.batch < 0 {
+		return fmt.Errorf("%w: batch must not be negative, got %d", ErrUsage, config.batch)
+	}
+
+	selectors := 0
+	for _, set := range []bool{config.step > 0, config.batch > 0, config.target != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return fmt.Errorf("%w: step, batch and target cannot be used together", ErrUsage)
+	}
+
+	identifiedMigrations, err := identifyMigrations(migrations)
+	if err != nil {
+		return err
+	}
+
+	records, err := getAppliedRecords(db, config.table, identifiedMigrations, config.pretend)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case config.step > 0:
+		// Get the last migrations across batches
+		records = reversedRecords(records)
+		if len(records) > config.step {
+			records = records[:config.step]
+		}
+	case config.batch > 0:
+		// Get migrations from the requested batch
+		records = batchRecords(records, config.batch)
+	case config.target != "":
+		if err := checkTarget(identifiedMigrations, config.target); err != nil {
+			return err
+		}
+		// Get migrations newer than the target, newest first
+		records = recordsAfter(records, config.target)
+	default:
+		// Get migrations from last batch
+		records = lastBatchRecords(records)
+	}
+
+	return rollbackRecords(db, identifiedMigrations, records, config)
+}
+
+// ResetMigrations rolls back every batch of migrations in reverse order
+func ResetMigrations(db *gorm.DB, migrations []Migration, opts ...RunOption) error {
+	return defaultMigrator.withMigrations(db, migrations).Reset(opts...)
+}
+
+// resetMigrations rolls back every batch of migrations in reverse order
+func resetMigrations(db *gorm.DB, migrations []Migration, config *runConfig) error {
+	identifiedMigrations, err := identifyMigrations(migrations)
+	if err != nil {
+		return err
 	}
 
-	// Get migrations from last batch
-	lastBatchMigrations, err := getLastBatchMigrations(db)
+	records, err := getAppliedRecords(db, config.table, identifiedMigrations, config.pretend)
 	if err != nil {
-		return fmt.Errorf("failed to get last batch migrations: %w", err)
+		return err
 	}
 
-	if len(lastBatchMigrations) == 0 {
-		fmt.Println("Nothing to rollback")
+	// Roll back every migration, newest first
+	return rollbackRecords(db, identifiedMigrations, reversedRecords(records), config)
+}
+
+// RefreshMigrations rolls back every migration and runs them all again
+func RefreshMigrations(db *gorm.DB, migrations []Migration, opts ...RunOption) error {
+	return defaultMigrator.withMigrations(db, migrations).Refresh(opts...)
+}
+
+// FreshMigrations drops every table in the database, including tables that
+// are not managed by migrations, and runs all migrations from scratch
+func FreshMigrations(db *gorm.DB, migrations []Migration, opts ...RunOption) error {
+	return defaultMigrator.withMigrations(db, migrations).Fresh(opts...)
+}
+
+// rollbackRecords rolls back the migrations of the records in the given order
+func rollbackRecords(db *gorm.DB, migrations []*identifiedMigration, records []MigrationRecord, config *runConfig) error {
+	if len(records) == 0 {
+		config.logger.Info("nothing to rollback")
 		return nil
 	}
 
 	// Create a map for quick lookup
-	migrationMap := make(map[string]Migration)
+	migrationMap := make(map[string]*identifiedMigration, len(migrations))
 	for _, migration := range migrations {
-		migrationMap[fmt.Sprintf("%T", migration)] = migration
+		migrationMap[migration.version] = migration
 	}
 
-	// Rollback migrations in reverse order
-	for _, migrationName := range lastBatchMigrations {
-		migration, ok := migrationMap[migrationName]
-		if !ok {
-			return fmt.Errorf("migration %s not found", migrationName)
-		}
+	// Refuse to start when any migration cannot be rolled back, rather than
+	// failing partway through
+	if missing := findMissingRecords(migrations, records); len(missing) > 0 {
+		return fmt.Errorf("%w: cannot roll back %s, their source is no longer loaded", ErrMissingMigrations, missingKeys(missing))
+	}
 
-		fmt.Printf("Rolling back migration %s...\n", migrationName)
+	// Resolve the migrations to roll back
+	rollbacks := make([]*identifiedMigration, len(records))
+	for i, record := range records {
+		rollbacks[i] = migrationMap[record.Version]
+	}
+	if missing := missingDownFiles(rollbacks); len(missing) > 0 {
+		return fmt.Errorf("cannot roll back %s, they have no %s file", strings.Join(missing, ", "), sqlDownSuffix)
+	}
 
-		// Run down migration
-		if err := migration.Down(db); err != nil {
-			return fmt.Errorf("failed to rollback migration %s: %w", migrationName, err)
+	if config.pretend {
+		for _, migration := range rollbacks {
+			down := func(db *gorm.DB) error {
+				return callDown(config.ctx, db, migration)
+			}
+			if err := pretendMigration(db, config.output, migration.key(), "down", down); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		// Remove migration record
-		if err := removeMigrationRecord(db, migrationName); err != nil {
-			return fmt.Errorf("failed to remove migration record %s: %w", migrationName, err)
+	if config.singleTransaction {
+		if err := requireTransactions(rollbacks); err != nil {
+			return err
 		}
 
-		fmt.Printf("Rolled back migration %s\n", migrationName)
+		return transaction(db, config, func(tx *gorm.DB) error {
+			for i, migration := range rollbacks {
+				if err := checkInterrupted(config, "rollback", migration); err != nil {
+					return err
+				}
+				if err := rollbackMigration(tx, config, migration, records[i]); err != nil {
+					return interruptedError(config, "rollback", migration, err)
+				}
+			}
+			return nil
+		})
+	}
+
+	// Rollback migrations in order
+	for i, migration := range rollbacks {
+		if err := checkInterrupted(config, "rollback", migration); err != nil {
+			return err
+		}
+		err := inTransaction(db, config, migration, func(tx *gorm.DB) error {
+			return rollbackMigration(tx, config, migration, records[i])
+		})
+		if err != nil {
+			return interruptedError(config, "rollback", migration, err)
+		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// dropAllTables drops every table in the database with foreign key checks
+// disabled, so tables can be dropped regardless of the references between them.
+// lockTable, the lock table of the run, is kept.
+func dropAllTables(db *gorm.DB, logger *slog.Logger, lockTable string) error {
+	// GetTables lists names without their schema
+	_, lockName := splitTableName(lockTable)
+
+	// Session settings such as FOREIGN_KEY_CHECKS only apply to the connection
+	// they were run on, so every statement runs on the same connection
+	return db.Connection(func(conn *gorm.DB) error {
+		tables, err := conn.Migrator().GetTables()
+		if err != nil {
+			return fmt.Errorf("failed to list tables: %w", err)
+		}
+
+		switch conn.Dialector.Name() {
+		case "mysql":
+			if err := conn.Exec("SET FOREIGN_KEY_CHECKS = 0").Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SET FOREIGN_KEY_CHECKS = 1")
+		case "sqlite":
+			if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+				return err
+			}
+			defer conn.Exec("PRAGMA foreign_keys = ON")
+		case "sqlserver":
+			// SQL Server cannot drop a referenced table even with its
+			// constraints disabled, so the foreign keys are dropped first
+			if err := conn.Exec(dropSQLServerForeignKeys).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, table := range tables {
+			// The lock table is kept since the lock is held right now
+			if strings.HasPrefix(table, "sqlite_") || table == lockName {
+				continue
+			}
+
+			// The Postgres migrator drops tables with CASCADE
+			logger.Info("dropping table", "table", table)
+			if err := conn.Migrator().DropTable(table); err != nil {
+				return fmt.Errorf("failed to drop table %s: %w", table, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// dropSQLServerForeignKeys drops every foreign key constraint of the database
+const dropSQLServerForeignKeys = `
+DECLARE @sql NVARCHAR(MAX) = N'';
+SELECT @sql += N'ALTER TABLE ' + QUOTENAME(OBJECT_SCHEMA_NAME(parent_object_id)) + N'.' + QUOTENAME(OBJECT_NAME(parent_object_id))
+	+ N' DROP CONSTRAINT ' + QUOTENAME(name) + N';'
+FROM sys.foreign_keys;
+EXEC sp_executesql @sql;`
@@ -0,0 +1,187 @@
+package migration
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// tableMigration creates a table named after it
+type tableMigration struct {
+	version string
+	name    string
+}
+
+func (m *tableMigration) Version() string { return m.version }
+func (m *tableMigration) Name() string    { return m.name }
+
+func (m *tableMigration) Up(db *gorm.DB) error {
+	return db.Exec("CREATE TABLE " + m.name + " (id INTEGER PRIMARY KEY)").Error
+}
+
+func (m *tableMigration) Down(db *gorm.DB) error {
+	return db.Exec("DROP TABLE " + m.name).Error
+}
+
+// openTestDB opens an empty SQLite database
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestMigrator returns a quiet Migrator running migrations on db
+func newTestMigrator(db *gorm.DB, migrations []Migration, opts ...Option) *Migrator {
+	opts = append([]Option{
+		WithDB(db),
+		WithSource(SourceFunc(func() ([]Migration, error) { return migrations, nil })),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithOutput(io.Discard),
+	}, opts...)
+	return New(opts...)
+}
+
+// appliedVersions lists the versions recorded in table, oldest first
+func appliedVersions(t *testing.T, db *gorm.DB, table string) []string {
+	t.Helper()
+	records, err := getMigrationRecords(db, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []string{}
+	for _, record := range records {
+		versions = append(versions, record.Version)
+	}
+	return versions
+}
+
+func TestEnsureMigrationsTableWithPrefix(t *testing.T) {
+	db := openTestDB(t)
+	table := newTestMigrator(db, nil, WithTablePrefix("svc_")).recordsTable()
+	if table != "svc_migration_records" {
+		t.Fatalf("recordsTable() = %q, want svc_migration_records", table)
+	}
+
+	// A table of an older release lacks the identity columns
+	if err := db.Exec("CREATE TABLE svc_migration_records (id INTEGER PRIMARY KEY, migration VARCHAR(255) NOT NULL, batch INTEGER NOT NULL, created_at DATETIME NOT NULL)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensuring again must leave the table as it is
+	for run := 1; run <= 2; run++ {
+		if err := ensureMigrationsTable(db, table); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		for _, column := range []string{"version", "name", "checksum"} {
+			if !db.Migrator().HasColumn(table, column) {
+				t.Errorf("run %d: %s has no %s column", run, table, column)
+			}
+		}
+	}
+	if db.Migrator().HasTable(defaultTableName) {
+		t.Errorf("unprefixed %s was created", defaultTableName)
+	}
+}
+
+func TestFreshWithPrefix(t *testing.T) {
+	db := openTestDB(t)
+	m := newTestMigrator(db, []Migration{
+		&tableMigration{"001", "users"},
+		&tableMigration{"002", "posts"},
+	}, WithTablePrefix("svc_"))
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE TABLE unmanaged (id INTEGER PRIMARY KEY)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock table holds the lock of the run, dropping it fails the run
+	if err := m.Fresh(); err != nil {
+		t.Fatalf("Fresh() = %v", err)
+	}
+	if db.Migrator().HasTable("unmanaged") {
+		t.Error("Fresh kept a table not managed by migrations")
+	}
+	for _, table := range []string{"svc_migration_locks", "users", "posts"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("table %s is missing after Fresh", table)
+		}
+	}
+	if got, want := appliedVersions(t, db, m.recordsTable()), []string{"001", "002"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied %v after Fresh, want %v", got, want)
+	}
+}
+
+func TestUpWithTarget(t *testing.T) {
+	db := openTestDB(t)
+	// The source does not list the migrations in version order
+	m := newTestMigrator(db, []Migration{
+		&tableMigration{"004", "comments"},
+		&tableMigration{"001", "users"},
+		&tableMigration{"003", "tags"},
+		&tableMigration{"002", "posts"},
+	})
+
+	if err := m.Up(WithTarget("002")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := appliedVersions(t, db, m.recordsTable()), []string{"001", "002"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied %v, want %v", got, want)
+	}
+
+	if err := m.Up(WithTarget("005")); err == nil {
+		t.Error("Up with an unknown target succeeded")
+	}
+}
+
+func TestRollbackSelection(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []RunOption
+		remain []string
+	}{
+		{"last batch", nil, []string{"001", "002"}},
+		{"step", []RunOption{WithStep(3)}, []string{"001"}},
+		{"batch", []RunOption{WithBatch(1)}, []string{"003", "004"}},
+		{"target", []RunOption{WithTarget("001")}, []string{"001"}},
+		{"target is newest", []RunOption{WithTarget("004")}, []string{"001", "002", "003", "004"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			m := newTestMigrator(db, []Migration{
+				&tableMigration{"001", "users"},
+				&tableMigration{"002", "posts"},
+				&tableMigration{"003", "tags"},
+				&tableMigration{"004", "comments"},
+			})
+
+			// Batch 1 holds 001 and 002, batch 2 holds 003 and 004
+			if err := m.Up(WithTarget("002")); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Up(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.Down(tt.opts...); err != nil {
+				t.Fatalf("Down() = %v", err)
+			}
+			if got := appliedVersions(t, db, m.recordsTable()); !reflect.DeepEqual(got, tt.remain) {
+				t.Errorf("%v remain applied, want %v", got, tt.remain)
+			}
+		})
+	}
+}
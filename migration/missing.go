@@ -0,0 +1,56 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrMissingMigrations is returned when applied migrations are no longer
+// loaded, for example because their file was deleted or renamed
+var ErrMissingMigrations = errors.New("missing migrations")
+
+// findMissingRecords returns the records whose migration is not loaded.
+// Legacy records nothing identifies have no version and are always missing.
+func findMissingRecords(migrations []*identifiedMigration, records []MigrationRecord) []MigrationRecord {
+	loaded := make(map[string]bool, len(migrations))
+	for _, migration := range migrations {
+		loaded[migration.version] = true
+	}
+
+	var missing []MigrationRecord
+	for _, record := range records {
+		if record.Version == "" || !loaded[record.Version] {
+			missing = append(missing, record)
+		}
+	}
+	return missing
+}
+
+// missingKeys returns the stored migration names of records
+func missingKeys(records []MigrationRecord) string {
+	keys := make([]string, len(records))
+	for i, record := range records {
+		keys[i] = record.Migration
+	}
+	return strings.Join(keys, ", ")
+}
+
+// checkMissing reports applied migrations that are no longer loaded, and
+// fails unless ignore is set
+func checkMissing(logger *slog.Logger, migrations []*identifiedMigration, records []MigrationRecord, ignore bool) error {
+	missing := findMissingRecords(migrations, records)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	for _, record := range missing {
+		logger.Warn("applied migration is no longer present", "version", record.Version, "name", record.Name, "migration", record.Migration, "batch", record.Batch)
+	}
+
+	if !ignore {
+		return fmt.Errorf("%w: %s were applied but are no longer loaded, restore them or ignore them deliberately", ErrMissingMigrations, missingKeys(missing))
+	}
+	return nil
+}
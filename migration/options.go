@@ -0,0 +1,186 @@
+package migration
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// RunOption configures RunMigrations and RollbackMigrations
+type RunOption func(*runConfig)
+
+// runConfig holds the settings built from RunOption values, and the logger,
+// output and table of the Migrator running them
+type runConfig struct {
+	ctx               context.Context
+	logger            *slog.Logger
+	output            io.Writer
+	table             string
+	seedersTable      string
+	singleTransaction bool
+	step              int
+	batch             int
+	target            string
+	outOfOrder        OutOfOrderPolicy
+	ignoreMissing     bool
+	pretend           bool
+	locker            Locker
+	noLock            bool
+	lockName          string
+	lockTable         string
+	lockTimeout       time.Duration
+	strictChecksums   bool
+	timeout           time.Duration
+	seeder            string
+	forceSeed         bool
+}
+
+// newRunConfig applies the options to the default configuration of m
+func (m *Migrator) newRunConfig(opts []RunOption) *runConfig {
+	config := &runConfig{
+		ctx:          context.Background(),
+		logger:       m.logger,
+		output:       m.output,
+		table:        m.recordsTable(),
+		seedersTable: m.seedersTable(),
+		lockName:     m.lockName(),
+		lockTable:    m.qualifiedTable(lockTableName),
+		lockTimeout:  defaultLockTimeout,
+		outOfOrder:   OutOfOrderError,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// WithSingleTransaction runs every pending migration inside one transaction
+// instead of one transaction per migration, so either all of them are applied
+// or none is. It is only useful on databases with transactional DDL such as
+// Postgres, and fails when a pending migration opts out of transactions.
+func WithSingleTransaction() RunOption {
+	return func(c *runConfig) {
+		c.singleTransaction = true
+	}
+}
+
+// WithStep makes RollbackMigrations roll back the last n migrations, crossing
+// batch boundaries when needed, instead of the last batch
+func WithStep(n int) RunOption {
+	return func(c *runConfig) {
+		c.step = n
+	}
+}
+
+// WithBatch makes RollbackMigrations roll back the migrations of batch n
+// instead of the last batch
+func WithBatch(n int) RunOption {
+	return func(c *runConfig) {
+		c.batch = n
+	}
+}
+
+// WithTarget makes RunMigrations apply pending migrations up to and including
+// version, and RollbackMigrations roll back every applied migration newer than
+// version, newest first and across batches
+func WithTarget(version string) RunOption {
+	return func(c *runConfig) {
+		c.target = version
+	}
+}
+
+// WithOutOfOrder sets what RunMigrations does with pending migrations older
+// than the newest applied migration. The default, also used for an empty
+// policy, is OutOfOrderError. An unknown policy fails the run with ErrUsage.
+func WithOutOfOrder(policy OutOfOrderPolicy) RunOption {
+	return func(c *runConfig) {
+		c.outOfOrder = policy
+	}
+}
+
+// WithIgnoreMissing lets RunMigrations run while applied migrations are no
+// longer loaded. Without it RunMigrations fails with ErrMissingMigrations
+// before running anything.
+func WithIgnoreMissing() RunOption {
+	return func(c *runConfig) {
+		c.ignoreMissing = true
+	}
+}
+
+// WithPretend prints the SQL each pending migration, or each migration to roll
+// back, would execute without running it. Migrations run against a GORM
+// session that prints its writes instead of executing them, reads still reach
+// the database, and no migration record is written.
+func WithPretend() RunOption {
+	return func(c *runConfig) {
+		c.pretend = true
+	}
+}
+
+// WithLocker sets the lock acquired around the whole run or rollback, so that
+// several processes migrating the same database wait for each other instead
+// of racing. Without it a locker chosen like DefaultLocker is used, named
+// after the migrations table so Migrators with their own table do not share
+// the lock.
+func WithLocker(locker Locker) RunOption {
+	return func(c *runConfig) {
+		c.locker = locker
+	}
+}
+
+// WithoutLock runs without acquiring any lock, for callers that already make
+// sure only one process migrates the database
+func WithoutLock() RunOption {
+	return func(c *runConfig) {
+		c.noLock = true
+	}
+}
+
+// WithLockTimeout sets how long to wait for the lock before failing with
+// ErrLockTimeout. The default is 5 minutes, zero waits forever.
+func WithLockTimeout(timeout time.Duration) RunOption {
+	return func(c *runConfig) {
+		c.lockTimeout = timeout
+	}
+}
+
+// WithStrictChecksums makes RunMigrations fail with ErrChecksumMismatch,
+// before running anything, when an applied migration was changed. Without it
+// changed migrations are only reported.
+func WithStrictChecksums() RunOption {
+	return func(c *runConfig) {
+		c.strictChecksums = true
+	}
+}
+
+// WithContext runs the migrations with ctx. The database handle passed to
+// each migration is bound to it, so cancelling ctx aborts the running
+// statement, and no further migration is started.
+func WithContext(ctx context.Context) RunOption {
+	return func(c *runConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithTimeout limits how long each migration may run. Migrations that
+// implement TimeoutMigration use their own timeout instead.
+func WithTimeout(timeout time.Duration) RunOption {
+	return func(c *runConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithSeederClass makes Seed run only the seeder registered under name
+func WithSeederClass(name string) RunOption {
+	return func(c *runConfig) {
+		c.seeder = name
+	}
+}
+
+// WithForceSeed makes Seed run run-once seeders that were already recorded
+func WithForceSeed() RunOption {
+	return func(c *runConfig) {
+		c.forceSeed = true
+	}
+}
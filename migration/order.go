@@ -0,0 +1,83 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// OutOfOrderPolicy decides what happens to a pending migration whose version
+// is older than the newest applied migration, which happens when branches
+// adding migrations are merged
+type OutOfOrderPolicy string
+
+const (
+	// OutOfOrderError refuses to run anything, it is the default
+	OutOfOrderError OutOfOrderPolicy = "error"
+	// OutOfOrderWarn logs a warning and applies the migrations
+	OutOfOrderWarn OutOfOrderPolicy = "warn"
+	// OutOfOrderAllow applies the migrations without a warning
+	OutOfOrderAllow OutOfOrderPolicy = "allow"
+)
+
+// ErrOutOfOrder is returned under OutOfOrderError when a pending migration is
+// older than the newest applied migration
+var ErrOutOfOrder = errors.New("out-of-order migrations")
+
+// parseOutOfOrderPolicy parses the value of the --out-of-order flag or of
+// WithOutOfOrder. An empty value is the default, OutOfOrderError.
+func parseOutOfOrderPolicy(s string) (OutOfOrderPolicy, error) {
+	switch policy := OutOfOrderPolicy(s); policy {
+	case "":
+		return OutOfOrderError, nil
+	case OutOfOrderError, OutOfOrderWarn, OutOfOrderAllow:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown out-of-order policy %q, use error, warn or allow", s)
+	}
+}
+
+// findOutOfOrder returns the pending migrations older than the newest applied
+// migration, and that version. Applied migrations that are no longer loaded
+// are not taken into account.
+func findOutOfOrder(migrations, pending []*identifiedMigration, applied map[string]bool) ([]*identifiedMigration, string) {
+	newest := ""
+	for _, migration := range migrations {
+		if applied[migration.version] && migration.version > newest {
+			newest = migration.version
+		}
+	}
+
+	var outOfOrder []*identifiedMigration
+	for _, migration := range pending {
+		if migration.version < newest {
+			outOfOrder = append(outOfOrder, migration)
+		}
+	}
+	return outOfOrder, newest
+}
+
+// checkOutOfOrder reports out-of-order pending migrations and applies the
+// policy to them
+func checkOutOfOrder(logger *slog.Logger, migrations, pending []*identifiedMigration, applied map[string]bool, policy OutOfOrderPolicy) error {
+	if policy == OutOfOrderAllow {
+		return nil
+	}
+
+	outOfOrder, newest := findOutOfOrder(migrations, pending, applied)
+	if len(outOfOrder) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(outOfOrder))
+	for i, migration := range outOfOrder {
+		logger.Warn("pending migration is older than the newest applied migration", "version", migration.version, "name", migration.name, "newest_applied", newest)
+		keys[i] = migration.key()
+	}
+
+	if policy == OutOfOrderError {
+		return fmt.Errorf("%w: %s predate applied migration %s, use the warn or allow policy to run them", ErrOutOfOrder, strings.Join(keys, ", "), newest)
+	}
+	return nil
+}
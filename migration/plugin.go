@@ -0,0 +1,181 @@
+package migration
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"plugin"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// loadPluginMigrations compiles a migrations directory into a Go plugin and
+// loads every migration exported by it, logging its progress to logger. The
+// plugin is reused from the cache while its inputs are unchanged, see
+// buildPlugin. A prebuilt plugin is loaded without compiling anything.
+func loadPluginMigrations(logger *slog.Logger, config pluginConfig) ([]Migration, error) {
+	if config.plugin != "" {
+		return loadPrebuiltPlugin(logger, config.plugin)
+	}
+
+	migrationsPath, filenames, err := pluginSources(logger, config.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// A directory of SQL migrations only has nothing to compile
+	if len(filenames) == 0 {
+		return nil, nil
+	}
+
+	pluginPath, cached, cleanup, err := buildPlugin(logger, config, migrationsPath, filenames)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	p, err := openPlugin(pluginPath)
+	if err != nil && cached {
+		// A cached plugin no longer matches the running binary when it was
+		// built against packages the hash does not cover, so build it again
+		logger.Warn("cached migrations plugin cannot be loaded, rebuilding", "path", pluginPath, "error", err)
+		p, err = replaceCachedPlugin(logger, migrationsPath, filenames, pluginPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("loaded migrations plugin", "path", pluginPath)
+
+	return lookupPluginMigrations(logger, p)
+}
+
+// replaceCachedPlugin rebuilds a cached plugin that failed to load. Go
+// remembers a failed plugin.Open by path and fails every later open of that
+// path, so the rebuild is loaded from a new file and then renamed over the
+// cache entry. When the stale plugin was already linked into the process, the
+// packages it defined stay loaded and the rebuild can only be used by the next
+// run.
+func replaceCachedPlugin(logger *slog.Logger, migrationsPath string, filenames []string, cachePath string) (*plugin.Plugin, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), strings.TrimSuffix(filepath.Base(cachePath), ".so")+"-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin build file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := compilePlugin(logger, migrationsPath, filenames, tmp.Name()); err != nil {
+		return nil, err
+	}
+	p, openErr := openPlugin(tmp.Name())
+
+	// A loaded plugin stays mapped when its file is renamed
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		logger.Debug("failed to store rebuilt migrations plugin", "path", cachePath, "error", err)
+	} else if openErr != nil {
+		return nil, fmt.Errorf("the stale migrations plugin was replaced by a rebuild, run the command again: %w", openErr)
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	return p, nil
+}
+
+// loadPrebuiltPlugin loads the migrations of a plugin built by migrate:build
+func loadPrebuiltPlugin(logger *slog.Logger, path string) ([]Migration, error) {
+	pluginPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve plugin path: %w", err)
+	}
+	if _, statErr := os.Stat(pluginPath); statErr != nil {
+		return nil, fmt.Errorf("migrations plugin not found: %w", statErr)
+	}
+
+	p, err := openPlugin(pluginPath)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("loaded prebuilt migrations plugin", "path", pluginPath)
+
+	return lookupPluginMigrations(logger, p)
+}
+
+// pluginSources returns the absolute migrations directory and the sorted Go
+// files in it
+func pluginSources(logger *slog.Logger, dir string) (string, []string, error) {
+	migrationsPath, err := filepath.Abs(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve migrations directory: %w", err)
+	}
+
+	// Check if migrations directory exists
+	if _, statErr := os.Stat(migrationsPath); os.IsNotExist(statErr) {
+		return "", nil, fmt.Errorf("migrations directory not found: %w", statErr)
+	}
+
+	// Get all migration files
+	files, err := os.ReadDir(migrationsPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	// Sort files by name to ensure migrations run in order
+	filenames := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".go" {
+			filenames = append(filenames, file.Name())
+		}
+	}
+	sort.Strings(filenames)
+	logger.Debug("found migration files", "dir", migrationsPath, "files", len(filenames))
+
+	// Files from make:migration belong to a package registering its
+	// migrations, which go build refuses to turn into a plugin
+	if pkg := goPackageName(migrationsPath); pkg != "" && pkg != "main" {
+		return "", nil, fmt.Errorf("no migrations registered: %s holds package %s, import it from your application so its migrations call migration.Register, only package main can be compiled as a plugin", dir, pkg)
+	}
+
+	return migrationsPath, filenames, nil
+}
+
+// lookupPluginMigrations returns the migration of every file listed in the
+// manifest of p
+func lookupPluginMigrations(logger *slog.Logger, p *plugin.Plugin) ([]Migration, error) {
+	files, values, err := readPluginManifest(p)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	for _, file := range files {
+		// The filename identifies the migration in the migrations table
+		// Format: YYYYMMDDHHMMSS_migration_name.go
+		version, name, _ := strings.Cut(strings.TrimSuffix(file.Name, ".go"), "_")
+
+		value, ok := values[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("plugin has no migration for %s", file.Name)
+		}
+
+		// The value is a pointer to a type implementing Migration, or a
+		// pointer to a pointer when taken from an _Exported pointer variable
+		migration, ok := value.(Migration)
+		if !ok {
+			valueOf := reflect.ValueOf(value)
+			if valueOf.Kind() == reflect.Ptr && valueOf.Elem().CanInterface() {
+				migration, ok = valueOf.Elem().Interface().(Migration)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s in %s does not implement Migration interface", file.Value, file.Name)
+		}
+
+		logger.Debug("loaded plugin migration", "version", version, "name", name, "value", file.Value, "type", fmt.Sprintf("%T", migration))
+		// The checksum of the source file detects edits after it was applied
+		migrations = append(migrations, withIdentity(migration, version, name, file.Checksum))
+	}
+
+	logger.Debug("loaded plugin migrations", "count", len(migrations))
+	return migrations, nil
+}
@@ -0,0 +1,277 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pluginCachePruneAge is how long a cached plugin may go unused before it is
+// removed
+const pluginCachePruneAge = 7 * 24 * time.Hour
+
+// pluginConfig tells loadPluginMigrations where to find and build the plugin
+type pluginConfig struct {
+	// dir holds the migration sources
+	dir string
+	// output is where the plugin is built, bypassing the cache
+	output string
+	// cacheDir holds plugins by the hash of their inputs, the user cache
+	// directory is used when empty
+	cacheDir string
+	// rebuild compiles the plugin even when a cached one matches
+	rebuild bool
+	// plugin is a prebuilt plugin loaded instead of compiling dir
+	plugin string
+}
+
+// defaultPluginCacheDir returns the cache directory used when none is set
+func defaultPluginCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-migration", "plugins"), nil
+}
+
+// buildPlugin returns the path of the compiled plugin of the migrations in
+// migrationsPath. A cached plugin is reused when the migration sources, the
+// local packages they import, the go.mod and go.sum of their module and the Go
+// version are unchanged, cached reports whether it was. cleanup removes
+// temporary files once the plugin is loaded.
+func buildPlugin(logger *slog.Logger, config pluginConfig, migrationsPath string, filenames []string) (path string, cached bool, cleanup func(), err error) {
+	cleanup = func() {}
+
+	if config.output != "" {
+		path, err = filepath.Abs(config.output)
+		if err != nil {
+			return "", false, cleanup, fmt.Errorf("failed to resolve plugin output path: %w", err)
+		}
+		return path, false, cleanup, compilePlugin(logger, migrationsPath, filenames, path)
+	}
+
+	cacheDir := config.cacheDir
+	if cacheDir == "" {
+		cacheDir, err = defaultPluginCacheDir()
+	}
+	if err == nil {
+		err = os.MkdirAll(cacheDir, 0o755)
+	}
+	var hash string
+	if err == nil {
+		hash, err = pluginHash(migrationsPath, filenames)
+	}
+	if err != nil {
+		// Without a cache the plugin is built into a temporary directory
+		logger.Debug("not caching migrations plugin", "error", err)
+		return buildTemporaryPlugin(logger, migrationsPath, filenames)
+	}
+
+	path = filepath.Join(cacheDir, hash+".so")
+	if _, statErr := os.Stat(path); statErr == nil && !config.rebuild {
+		logger.Debug("using cached migrations plugin", "path", path)
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return path, true, cleanup, nil
+	}
+
+	// Build next to the cache entry and rename it into place, so concurrent
+	// runs never load a partially written plugin
+	tmp, err := os.CreateTemp(cacheDir, hash+"-*.tmp")
+	if err != nil {
+		return "", false, cleanup, fmt.Errorf("failed to create plugin build file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := compilePlugin(logger, migrationsPath, filenames, tmp.Name()); err != nil {
+		return "", false, cleanup, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", false, cleanup, fmt.Errorf("failed to store migrations plugin: %w", err)
+	}
+
+	prunePluginCache(logger, cacheDir, path)
+	return path, false, cleanup, nil
+}
+
+// buildTemporaryPlugin builds the plugin into a temporary directory. A loaded
+// plugin stays mapped after its file is removed.
+func buildTemporaryPlugin(logger *slog.Logger, migrationsPath string, filenames []string) (string, bool, func(), error) {
+	tmp, err := os.MkdirTemp("", "go-migration-")
+	if err != nil {
+		return "", false, func() {}, fmt.Errorf("failed to create plugin build directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	path := filepath.Join(tmp, "migrations.so")
+	if err := compilePlugin(logger, migrationsPath, filenames, path); err != nil {
+		cleanup()
+		return "", false, func() {}, err
+	}
+	return path, false, cleanup, nil
+}
+
+// compilePlugin compiles the migrations directory into a plugin, together
+// with a manifest of filenames. The build runs in the directory itself so the
+// module containing it is used.
+func compilePlugin(logger *slog.Logger, migrationsPath string, filenames []string, output string) error {
+	overlay, cleanup, err := writeManifestOverlay(migrationsPath, filenames)
+	if err != nil {
+		return fmt.Errorf("failed to generate plugin manifest: %w", err)
+	}
+	defer cleanup()
+
+	logger.Debug("compiling migrations plugin", "dir", migrationsPath, "output", output)
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-overlay", overlay, "-o", output, ".")
+	cmd.Dir = migrationsPath
+
+	// The build output goes to the logger and the error, never to the output
+	// of the run, which may be parsed
+	combined, err := cmd.CombinedOutput()
+	out := strings.TrimSpace(string(combined))
+	if out != "" {
+		logger.Debug("go build output", "output", out)
+	}
+	if err != nil {
+		if out != "" {
+			return fmt.Errorf("failed to compile migrations: %w\n%s", err, out)
+		}
+		return fmt.Errorf("failed to compile migrations: %w", err)
+	}
+	return nil
+}
+
+// pluginHash hashes everything the compiled plugin depends on: the manifest
+// format, the migration sources, the sources of the local packages they
+// import, the go.mod and go.sum of their module, the Go toolchain building the
+// plugin and the runtime loading it
+func pluginHash(migrationsPath string, filenames []string) (string, error) {
+	cmd := exec.Command("go", "env", "-json", "GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS", "GOMOD")
+	cmd.Dir = migrationsPath
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query go env: %w", err)
+	}
+	var env map[string]string
+	if err := json.Unmarshal(out, &env); err != nil {
+		return "", fmt.Errorf("failed to read go env: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "manifest %d\n", pluginManifestVersion)
+	fmt.Fprintf(h, "runtime %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	for _, key := range []string{"GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS", "GOMOD"} {
+		fmt.Fprintf(h, "%s=%q\n", key, env[key])
+	}
+
+	files := make(map[string]string, len(filenames)+2)
+	if goMod := env["GOMOD"]; goMod != "" && goMod != os.DevNull {
+		files["go.mod"] = goMod
+		files["go.sum"] = filepath.Join(filepath.Dir(goMod), "go.sum")
+	}
+	for _, filename := range filenames {
+		files[filename] = filepath.Join(migrationsPath, filename)
+	}
+	packages, err := localPackageFiles(migrationsPath)
+	if err != nil {
+		return "", err
+	}
+	for name, file := range packages {
+		files[name] = file
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f, err := os.Open(files[name])
+		if os.IsNotExist(err) {
+			// A module without dependencies has no go.sum
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "\x00%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// localPackageListFormat makes go list print the source files of the packages
+// that do not come from the module cache: those of the main module, of the
+// workspace and of modules replaced by a directory. The module cache is
+// covered by go.sum.
+const localPackageListFormat = `{{if and (not .Standard) .Module}}` +
+	`{{if or .Module.Main (and .Module.Replace (not .Module.Replace.Version))}}` +
+	`{{$pkg := .}}{{range .GoFiles}}{{$pkg.ImportPath}}{{"\t"}}{{$pkg.Dir}}{{"\t"}}{{.}}{{"\n"}}{{end}}` +
+	`{{range .CgoFiles}}{{$pkg.ImportPath}}{{"\t"}}{{$pkg.Dir}}{{"\t"}}{{.}}{{"\n"}}{{end}}` +
+	`{{range .EmbedFiles}}{{$pkg.ImportPath}}{{"\t"}}{{$pkg.Dir}}{{"\t"}}{{.}}{{"\n"}}{{end}}` +
+	`{{end}}{{end}}`
+
+// localPackageFiles returns the source files of the local packages the
+// migrations in migrationsPath import, keyed by import path and filename. A
+// change to application code the migrations use changes the plugin, so it
+// has to change the hash too.
+func localPackageFiles(migrationsPath string) (map[string]string, error) {
+	cmd := exec.Command("go", "list", "-deps", "-f", localPackageListFormat, ".")
+	cmd.Dir = migrationsPath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migration dependencies: %w", err)
+	}
+
+	files := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) != 3 {
+			continue
+		}
+		files[parts[0]+"/"+parts[2]] = filepath.Join(parts[1], parts[2])
+	}
+	return files, nil
+}
+
+// prunePluginCache removes cached plugins, and builds left behind by crashed
+// runs, that have not been used for pluginCachePruneAge
+func prunePluginCache(logger *slog.Logger, cacheDir, keep string) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-pluginCachePruneAge)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(cacheDir, name)
+		if path == keep || entry.IsDir() || !(strings.HasSuffix(name, ".so") || strings.HasSuffix(name, ".tmp")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			logger.Debug("pruned cached migrations plugin", "path", path)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package migration
+
+import (
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"plugin"
+	"runtime/debug"
+	"strings"
+)
+
+// ErrIncompatiblePlugin is returned when a migrations plugin was built with a
+// Go version or module versions that differ from the running binary
+var ErrIncompatiblePlugin = errors.New("incompatible migrations plugin")
+
+// openPlugin opens a migrations plugin after checking it was built for the
+// running binary
+func openPlugin(path string) (*plugin.Plugin, error) {
+	if err := checkPluginCompatibility(path); err != nil {
+		return nil, err
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations plugin: %w", err)
+	}
+	return p, nil
+}
+
+// checkPluginCompatibility compares the build information of a plugin with the
+// running binary. Go refuses to load a plugin built with another Go version or
+// other versions of the modules both use, but only names the first package
+// that differs. Plugins or binaries without build information are left to
+// plugin.Open.
+func checkPluginCompatibility(path string) error {
+	host, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	built, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var problems []string
+	if built.GoVersion != host.GoVersion {
+		problems = append(problems, fmt.Sprintf("Go version: plugin %s, binary %s", built.GoVersion, host.GoVersion))
+	}
+
+	hostModules := make(map[string]string, len(host.Deps))
+	for _, dep := range host.Deps {
+		hostModules[dep.Path] = moduleVersion(dep)
+	}
+	for _, dep := range built.Deps {
+		hostVersion, ok := hostModules[dep.Path]
+		if ok && hostVersion != moduleVersion(dep) {
+			problems = append(problems, fmt.Sprintf("%s: plugin %s, binary %s", dep.Path, moduleVersion(dep), hostVersion))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s was not built for this binary (%s), rebuild it with migrate:build using the Go version and go.mod of this binary",
+			ErrIncompatiblePlugin, path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// moduleVersion describes the version of a module a build used, including
+// its replacement
+func moduleVersion(module *debug.Module) string {
+	if module.Replace == nil {
+		return module.Version
+	}
+	if module.Replace.Version == "" {
+		return module.Version + " => " + module.Replace.Path
+	}
+	return module.Version + " => " + module.Replace.Path + " " + module.Replace.Version
+}
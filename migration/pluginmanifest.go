@@ -0,0 +1,254 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strconv"
+	"strings"
+)
+
+// pluginManifestVersion is the format of the manifest compiled into plugins.
+// It is part of the cache key, so plugins of another format are rebuilt.
+const pluginManifestVersion = 2
+
+// pluginManifestSymbol is the variable holding the manifest in a plugin
+const pluginManifestSymbol = "GoMigrationManifest"
+
+// pluginMigrationsSymbol is the variable mapping each migration file to its
+// migration in a plugin
+const pluginMigrationsSymbol = "GoMigrationMigrations"
+
+// pluginManifestFile is the file the manifest is compiled from. It only
+// exists in the build overlay, never in the migrations directory.
+const pluginManifestFile = "zz_go_migration_manifest.go"
+
+// pluginManifest lists the migration files a plugin was built from, so a
+// prebuilt plugin can be loaded without its sources
+type pluginManifest struct {
+	Version int                   `json:"version"`
+	Files   []pluginMigrationFile `json:"files"`
+}
+
+// pluginMigrationFile is a migration source file compiled into a plugin
+type pluginMigrationFile struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+	// Value is the Go expression the migration is taken from
+	Value string `json:"value"`
+}
+
+// discoverMigrations finds the migration declared in each file of the
+// migrations package: the type with both an Up and a Down method. Its
+// <type>_Exported variable is used when one is declared, so its fields are
+// kept, otherwise a new value of the type. Files without such a type are
+// helpers and skipped. Only the AST is read, so type names do not have to
+// follow any convention.
+func discoverMigrations(migrationsPath string, filenames []string) (pkg string, files []pluginMigrationFile, err error) {
+	fset := token.NewFileSet()
+	parsed := make(map[string]*ast.File, len(filenames))
+	methods := make(map[string]map[string]bool)
+	vars := make(map[string]bool)
+
+	for _, filename := range filenames {
+		// go build ignores tests
+		if strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(migrationsPath, filename), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse migration file: %w", err)
+		}
+		parsed[filename] = file
+		pkg = file.Name.Name
+
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) != 1 {
+					continue
+				}
+				if receiver := receiverTypeName(decl.Recv.List[0].Type); receiver != "" {
+					if methods[receiver] == nil {
+						methods[receiver] = make(map[string]bool)
+					}
+					methods[receiver][decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				if decl.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					for _, name := range spec.(*ast.ValueSpec).Names {
+						vars[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, filename := range filenames {
+		file, ok := parsed[filename]
+		if !ok {
+			continue
+		}
+
+		var types []string
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				name := typeSpec.Name.Name
+				// A generic type cannot be instantiated without arguments
+				if typeSpec.TypeParams == nil && methods[name]["Up"] && methods[name]["Down"] {
+					types = append(types, name)
+				}
+			}
+		}
+
+		switch {
+		case len(types) == 0:
+			continue
+		case len(types) > 1:
+			return "", nil, fmt.Errorf("%s declares several migrations (%s), declare one per file", filename, strings.Join(types, ", "))
+		}
+		if _, _, ok := strings.Cut(strings.TrimSuffix(filename, ".go"), "_"); !ok {
+			return "", nil, fmt.Errorf("%s declares migration %s but is not named <version>_<name>.go", filename, types[0])
+		}
+
+		value := "new(" + types[0] + ")"
+		if vars[types[0]+"_Exported"] {
+			// Taking the address works for both value and pointer variables
+			value = "&" + types[0] + "_Exported"
+		}
+		files = append(files, pluginMigrationFile{
+			Name:     filename,
+			Checksum: checksumFile(filepath.Join(migrationsPath, filename)),
+			Value:    value,
+		})
+	}
+
+	return pkg, files, nil
+}
+
+// goPackageName returns the package of the Go files in dir, or an empty string
+// when it has none
+func goPackageName(dir string) string {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != ".go" || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil {
+			return parsed.Name.Name
+		}
+	}
+	return ""
+}
+
+// receiverTypeName returns the type name of a method receiver, or an empty
+// string for receivers of generic types
+func receiverTypeName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// writeManifestOverlay writes a go build overlay adding the manifest of the
+// migrations discovered in filenames to the package in migrationsPath.
+// cleanup removes the overlay once the build is done.
+func writeManifestOverlay(migrationsPath string, filenames []string) (overlay string, cleanup func(), err error) {
+	pkg, files, err := discoverMigrations(migrationsPath, filenames)
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	data, err := json.Marshal(pluginManifest{Version: pluginManifestVersion, Files: files})
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	// The generated file belongs to the package of the migrations, so it can
+	// refer to unexported types
+	var source bytes.Buffer
+	fmt.Fprintf(&source, "// Code generated by go-migration. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&source, "var %s = %s\n\n", pluginManifestSymbol, strconv.Quote(string(data)))
+	fmt.Fprintf(&source, "var %s = map[string]interface{}{\n", pluginMigrationsSymbol)
+	for _, file := range files {
+		fmt.Fprintf(&source, "\t%s: %s,\n", strconv.Quote(file.Name), file.Value)
+	}
+	fmt.Fprintf(&source, "}\n")
+
+	tmp, err := os.MkdirTemp("", "go-migration-overlay-")
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+
+	generated := filepath.Join(tmp, pluginManifestFile)
+	overlay = filepath.Join(tmp, "overlay.json")
+	replace, err := json.Marshal(map[string]map[string]string{
+		"Replace": {filepath.Join(migrationsPath, pluginManifestFile): generated},
+	})
+	if err == nil {
+		err = os.WriteFile(generated, source.Bytes(), 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(overlay, replace, 0o644)
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return overlay, cleanup, nil
+}
+
+// readPluginManifest returns the migration files compiled into p and the
+// migration of each
+func readPluginManifest(p *plugin.Plugin) ([]pluginMigrationFile, map[string]interface{}, error) {
+	sym, err := p.Lookup(pluginManifestSymbol)
+	if err != nil {
+		return nil, nil, errors.New("plugin has no migrations manifest, build it with migrate:build")
+	}
+	data, ok := sym.(*string)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s of the plugin is not a string", pluginManifestSymbol)
+	}
+
+	var manifest pluginManifest
+	if err := json.Unmarshal([]byte(*data), &manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	if manifest.Version != pluginManifestVersion {
+		return nil, nil, fmt.Errorf("plugin manifest version %d is not supported by this binary, rebuild the plugin with migrate:build", manifest.Version)
+	}
+
+	sym, err = p.Lookup(pluginMigrationsSymbol)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup plugin migrations: %w", err)
+	}
+	migrations, ok := sym.(*map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("%s of the plugin is not a map of migrations", pluginMigrationsSymbol)
+	}
+	return manifest.Files, *migrations, nil
+}
@@ -0,0 +1,142 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// sqlRecorder is a connection pool that prints the statements sent to the
+// database instead of executing them. Reads are passed on to the database, so
+// the GORM migrator can still inspect the schema and migrations can still look
+// up data.
+type sqlRecorder struct {
+	pool       gorm.ConnPool
+	dialector  gorm.Dialector
+	out        io.Writer
+	statements int
+}
+
+func (r *sqlRecorder) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return r.pool.PrepareContext(ctx, query)
+}
+
+func (r *sqlRecorder) ExecContext(_ context.Context, query string, args ...interface{}) (sql.Result, error) {
+	r.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (r *sqlRecorder) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if isReadStatement(query) {
+		return r.pool.QueryContext(ctx, query, args...)
+	}
+	// Inserts with a RETURNING clause are sent as queries, they return no
+	// rows like any write in pretend mode
+	r.record(query, args)
+	return r.pool.QueryContext(ctx, r.emptyQuery())
+}
+
+func (r *sqlRecorder) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if isReadStatement(query) {
+		return r.pool.QueryRowContext(ctx, query, args...)
+	}
+	r.record(query, args)
+	return r.pool.QueryRowContext(ctx, r.emptyQuery())
+}
+
+// BeginTx lets migrations open transactions, which are never committed as
+// nothing is written
+func (r *sqlRecorder) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &sqlRecorderTx{r}, nil
+}
+
+// sqlRecorderTx is a transaction begun on a sqlRecorder
+type sqlRecorderTx struct {
+	*sqlRecorder
+}
+
+func (*sqlRecorderTx) Commit() error { return nil }
+
+func (*sqlRecorderTx) Rollback() error { return nil }
+
+// record prints a statement with its arguments
+func (r *sqlRecorder) record(query string, args []interface{}) {
+	query = strings.TrimSpace(query)
+	// Savepoints of nested transactions are not part of the migration
+	if query == "" || isTransactionStatement(query) {
+		return
+	}
+	r.statements++
+	fmt.Fprintln(r.out, strings.TrimSuffix(r.dialector.Explain(query, args...), ";")+";")
+}
+
+// emptyQuery returns a query without rows
+func (r *sqlRecorder) emptyQuery() string {
+	if r.dialector.Name() == "mysql" {
+		return "SELECT 1 FROM DUAL WHERE 1 = 0"
+	}
+	return "SELECT 1 WHERE 1 = 0"
+}
+
+// isReadStatement reports whether a statement only reads from the database
+func isReadStatement(sql string) bool {
+	keyword, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	switch strings.ToUpper(keyword) {
+	case "SELECT", "SHOW", "PRAGMA":
+		return true
+	}
+	return false
+}
+
+// isTransactionStatement reports whether a statement controls a transaction
+func isTransactionStatement(sql string) bool {
+	keyword, _, _ := strings.Cut(sql, " ")
+	switch strings.ToUpper(keyword) {
+	case "SAVEPOINT", "RELEASE", "ROLLBACK":
+		return true
+	}
+	return false
+}
+
+// pretendMigration runs fn against a session whose writes are printed to out
+// instead of being executed
+func pretendMigration(db *gorm.DB, out io.Writer, migrationName, direction string, fn func(*gorm.DB) error) error {
+	recorder := &sqlRecorder{pool: db.Statement.ConnPool, dialector: db.Dialector, out: out}
+	// A session with a context gets its own statement to swap the pool of
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	session := db.Session(&gorm.Session{Context: ctx})
+	session.Statement.ConnPool = recorder
+
+	fmt.Fprintf(out, "-- %s (%s)\n", migrationName, direction)
+	if err := fn(session); err != nil {
+		return fmt.Errorf("failed to pretend migration %s: %w", migrationName, err)
+	}
+
+	if recorder.statements == 0 {
+		fmt.Fprintln(out, "-- no statements")
+	}
+	fmt.Fprintln(out)
+
+	return nil
+}
+
+// pretendMigrations prints the SQL of every pending migration
+func pretendMigrations(db *gorm.DB, config *runConfig, pending []*identifiedMigration) error {
+	for _, migration := range pending {
+		up := func(db *gorm.DB) error {
+			return callUp(config.ctx, db, migration)
+		}
+		if err := pretendMigration(db, config.output, migration.key(), "up", up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
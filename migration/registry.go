@@ -0,0 +1,97 @@
+package migration
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// registeredMigration is a migration added to the registry with Register
+type registeredMigration struct {
+	version   string
+	name      string
+	migration Migration
+	// file is the source file that called Register, used for its checksum
+	file string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]registeredMigration)
+)
+
+// Register adds a migration to the registry. It is meant to be called from the
+// init() function of each migration file:
+//
+//	func init() {
+//		migration.Register("20240601000000", "create_users_table", &CreateUsersTable{})
+//	}
+//
+// Register panics if the migration is nil, the version is empty or the version
+// has already been registered.
+//
+// The checksum of the calling source file is stored when the migration is
+// applied, so edits made afterwards are reported. Implement Checksum() to
+// provide it when the sources are not available at runtime.
+func Register(version, name string, m Migration) {
+	_, file, _, _ := runtime.Caller(1)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m == nil {
+		panic("migration: Register migration is nil")
+	}
+	if version == "" {
+		panic("migration: Register version is empty")
+	}
+	if existing, dup := registry[version]; dup {
+		panic(fmt.Sprintf("migration: Register called twice for version %s (%s and %s)", version, existing.name, name))
+	}
+
+	registry[version] = registeredMigration{
+		version:   version,
+		name:      name,
+		migration: m,
+		file:      file,
+	}
+}
+
+// RegisteredMigrations returns every registered migration ordered by version.
+// The migrations report the version and name they were registered with, and
+// the checksum of their source file when it can be read.
+func RegisteredMigrations() []Migration {
+	entries := registeredEntries()
+
+	migrations := make([]Migration, len(entries))
+	for i, entry := range entries {
+		migrations[i] = withIdentity(entry.migration, entry.version, entry.name, checksumFile(entry.file))
+	}
+
+	return migrations
+}
+
+// registeredEntries returns the registry entries ordered by version
+func registeredEntries() []registeredMigration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	entries := make([]registeredMigration, 0, len(registry))
+	for _, entry := range registry {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].version < entries[j].version
+	})
+
+	return entries
+}
+
+// hasRegisteredMigrations reports whether Register has been called
+func hasRegisteredMigrations() bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return len(registry) > 0
+}
@@ -0,0 +1,274 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Seeder fills the database with data such as lookup tables, admin users or
+// demo data
+type Seeder interface {
+	Run(*gorm.DB) error
+}
+
+// RunOnceSeeder can be implemented by a seeder that must not be applied twice
+// to the same database. When RunOnce returns true the seeder is recorded in
+// the seeders table and skipped by later runs unless they are forced.
+type RunOnceSeeder interface {
+	RunOnce() bool
+}
+
+// SeederRecord represents a record in the seeders table
+type SeederRecord struct {
+	ID        uint      `gorm:"primaryKey"`
+	Seeder    string    `gorm:"size:255;not null;unique"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// ErrUnknownSeeder is returned when the seeder asked for is not registered
+var ErrUnknownSeeder = errors.New("unknown seeder")
+
+// defaultSeedersDir is the directory seeders are created in unless
+// WithSeedersDir is used
+const defaultSeedersDir = "seeders"
+
+// defaultSeedersTableName is the table run-once seeders are recorded in,
+// prefixed and qualified like the migrations table
+const defaultSeedersTableName = "seeder_records"
+
+// registeredSeeder is a seeder added with RegisterSeeder
+type registeredSeeder struct {
+	name   string
+	seeder Seeder
+}
+
+var (
+	seedersMu sync.RWMutex
+	seeders   []registeredSeeder
+)
+
+// RegisterSeeder adds a seeder under name. It is meant to be called from the
+// init() function of each seeder file:
+//
+//	func init() {
+//		migration.RegisterSeeder("UsersSeeder", &UsersSeeder{})
+//	}
+//
+// Seeders run in the order they are registered. Go runs the init functions of
+// a package in filename order, so the seeders created by make:seeder run in
+// the order they were created.
+//
+// RegisterSeeder panics if the seeder is nil, the name is empty or the name
+// has already been registered.
+func RegisterSeeder(name string, s Seeder) {
+	seedersMu.Lock()
+	defer seedersMu.Unlock()
+
+	if s == nil {
+		panic("migration: RegisterSeeder seeder is nil")
+	}
+	if name == "" {
+		panic("migration: RegisterSeeder name is empty")
+	}
+	for _, entry := range seeders {
+		if entry.name == name {
+			panic(fmt.Sprintf("migration: RegisterSeeder called twice for %s", name))
+		}
+	}
+
+	seeders = append(seeders, registeredSeeder{name: name, seeder: s})
+}
+
+// registeredSeeders returns the registered seeders in registration order
+func registeredSeeders() []registeredSeeder {
+	seedersMu.RLock()
+	defer seedersMu.RUnlock()
+
+	return append([]registeredSeeder(nil), seeders...)
+}
+
+// runsOnce reports whether a seeder opted into being recorded
+func runsOnce(s Seeder) bool {
+	once, ok := s.(RunOnceSeeder)
+	return ok && once.RunOnce()
+}
+
+// CreateSeeder membuat file seeder baru
+func CreateSeeder(name string) error {
+	return defaultMigrator.CreateSeeder(name)
+}
+
+// createSeeder membuat file seeder baru di direktori dir
+func createSeeder(logger *slog.Logger, dir, name string) error {
+	// Membuat direktori seeders jika belum ada
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create seeders directory: %w", err)
+	}
+
+	// The timestamp keeps the files, and so the init functions registering
+	// them, in creation order
+	timestamp := time.Now().Format("20060102150405")
+	filePath := filepath.Join(dir, fmt.Sprintf("%s_%s.go", timestamp, snakeCase(name)))
+
+	structName := camelCase(name)
+	if !strings.HasSuffix(structName, "Seeder") {
+		structName += "Seeder"
+	}
+
+	tmpl, err := template.New("seeder").Parse(seederTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse seeder template: %w", err)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create seeder file: %w", err)
+	}
+	defer file.Close()
+
+	data := struct {
+		StructName string
+	}{
+		StructName: structName,
+	}
+
+	if err := tmpl.Execute(file, data); err != nil {
+		return fmt.Errorf("failed to generate seeder content: %w", err)
+	}
+
+	logger.Info("created seeder", "path", filePath, "seeder", structName)
+	return nil
+}
+
+// selectSeeders returns the registered seeders to run, only the one named
+// class when it is set
+func selectSeeders(class string) ([]registeredSeeder, error) {
+	entries := registeredSeeders()
+	if class == "" {
+		return entries, nil
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		if entry.name == class {
+			return []registeredSeeder{entry}, nil
+		}
+		names[i] = entry.name
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w %q, no seeders are registered", ErrUnknownSeeder, class)
+	}
+	return nil, fmt.Errorf("%w %q, registered seeders are %s", ErrUnknownSeeder, class, strings.Join(names, ", "))
+}
+
+// getSeederRecords returns the names of the seeders recorded in table,
+// creating it when missing
+func getSeederRecords(db *gorm.DB, table string) (map[string]bool, error) {
+	if err := ensureSeedersTable(db, table); err != nil {
+		return nil, fmt.Errorf("failed to create seeders table: %w", err)
+	}
+
+	var records []SeederRecord
+	if err := db.Table(table).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to get seeder records: %w", err)
+	}
+
+	applied := make(map[string]bool, len(records))
+	for _, record := range records {
+		applied[record.Seeder] = true
+	}
+	return applied, nil
+}
+
+// recordSeeder records that a run-once seeder has been run
+func recordSeeder(db *gorm.DB, table, name string) error {
+	return insertInto(db, table).Create(&SeederRecord{
+		Seeder:    name,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// runSeeders runs the selected seeders in registration order, each inside a
+// transaction. Run-once seeders that were recorded are skipped unless the run
+// is forced.
+func runSeeders(db *gorm.DB, config *runConfig) error {
+	entries, err := selectSeeders(config.seeder)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		config.logger.Info("no seeders registered")
+		return nil
+	}
+
+	// The seeders table is only created once a seeder asks to be recorded
+	applied := map[string]bool{}
+	for _, entry := range entries {
+		if runsOnce(entry.seeder) {
+			if applied, err = getSeederRecords(db, config.seedersTable); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, entry := range entries {
+		logger := config.logger.With("seeder", entry.name)
+		once := runsOnce(entry.seeder)
+		if once && applied[entry.name] && !config.forceSeed {
+			logger.Info("seeder already ran, skipping")
+			continue
+		}
+		if err := config.ctx.Err(); err != nil {
+			return fmt.Errorf("%w before seeder %s: %v", ErrInterrupted, entry.name, err)
+		}
+
+		logger.Info("running seeder")
+		start := time.Now()
+
+		// WithTimeout limits each seeder like each migration
+		ctx, cancel := config.ctx, context.CancelFunc(func() {})
+		if config.timeout > 0 {
+			ctx, cancel = context.WithTimeout(config.ctx, config.timeout)
+		}
+		// Like migrations, only the seeder gets the cancellable context so a
+		// seeder that completed is still recorded
+		err := transaction(db, config, func(tx *gorm.DB) error {
+			if err := entry.seeder.Run(tx.WithContext(ctx)); err != nil {
+				return err
+			}
+			if once && !applied[entry.name] {
+				if err := recordSeeder(tx, config.seedersTable, entry.name); err != nil {
+					return fmt.Errorf("failed to record seeder: %w", err)
+				}
+			}
+			return nil
+		})
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+		if err != nil {
+			switch {
+			case config.ctx.Err() != nil:
+				err = fmt.Errorf("%w: %v", ErrInterrupted, err)
+			case timedOut:
+				err = fmt.Errorf("timed out after %s: %w", config.timeout, err)
+			}
+			logger.Error("seeder failed", "duration", time.Since(start), "error", err)
+			return fmt.Errorf("failed to run seeder %s: %w", entry.name, err)
+		}
+
+		logger.Info("seeder completed", "duration", time.Since(start))
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package migration
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// Source provides the migrations used by ExecuteCommand
+type Source interface {
+	Load() ([]Migration, error)
+}
+
+// SourceFunc adapts an ordinary function to the Source interface
+type SourceFunc func() ([]Migration, error)
+
+// Load calls f()
+func (f SourceFunc) Load() ([]Migration, error) {
+	return f()
+}
+
+// SetMigrationSource sets the source ExecuteCommand loads migrations from.
+// Passing nil restores the default: registered migrations when Register has
+// been called, otherwise the migrations directory compiled as a plugin, merged
+// with the SQL migration files of the migrations directory.
+func SetMigrationSource(src Source) {
+	defaultMigrator.source = src
+}
+
+// SetMigrationsFS makes the default source read SQL migration files from dir
+// in fsys instead of the migrations directory of the working directory, so
+// they can be embedded into the binary:
+//
+//	//go:embed migrations
+//	var migrationsFS embed.FS
+//
+//	migration.SetMigrationsFS(migrationsFS, "migrations")
+//
+// The plugin source is not used when a file system is set, Go migrations
+// must be added with Register. Passing nil restores the working directory.
+func SetMigrationsFS(fsys fs.FS, dir string) {
+	defaultMigrator.fsys = fsys
+	defaultMigrator.fsDir = dir
+}
+
+// RegistrySource returns a Source that provides the migrations added with
+// Register, ordered by version
+func RegistrySource() Source {
+	return SourceFunc(func() ([]Migration, error) {
+		return RegisteredMigrations(), nil
+	})
+}
+
+// PluginSource returns a Source that compiles the migrations directory into a
+// Go plugin and loads the migrations exported by it. Building plugins requires
+// cgo and a Go toolchain on the machine running the migrations.
+func PluginSource() Source {
+	return PluginSourceDir(defaultMigrationsDir)
+}
+
+// PluginSourceDir returns a Source like PluginSource for the migrations in dir
+func PluginSourceDir(dir string) Source {
+	return SourceFunc(func() ([]Migration, error) {
+		return loadPluginMigrations(slog.Default(), pluginConfig{dir: dir})
+	})
+}
+
+// SQLSource returns a Source that loads <version>_<name>.up.sql and
+// <version>_<name>.down.sql files from dir. The .down.sql file is optional,
+// rolling back a migration without one fails.
+func SQLSource(dir string) Source {
+	return FSSource(os.DirFS(dir), ".")
+}
+
+// FSSource returns a Source that loads the SQL migration files of dir in
+// fsys, such as an embed.FS, the same way SQLSource does
+func FSSource(fsys fs.FS, dir string) Source {
+	return SourceFunc(func() ([]Migration, error) {
+		return loadSQLMigrations(fsys, dir)
+	})
+}
+
+// MultiSource returns a Source that merges the migrations of several sources,
+// ordered by version, for example Go migrations and SQL files:
+//
+//	migration.SetMigrationSource(migration.MultiSource(
+//		migration.RegistrySource(),
+//		migration.SQLSource("migrations"),
+//	))
+func MultiSource(sources ...Source) Source {
+	return SourceFunc(func() ([]Migration, error) {
+		var migrations []Migration
+		for _, src := range sources {
+			loaded, err := src.Load()
+			if err != nil {
+				return nil, err
+			}
+			migrations = append(migrations, loaded...)
+		}
+
+		sortByVersion(migrations)
+		return migrations, nil
+	})
+}
@@ -0,0 +1,282 @@
+package migration
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	sqlUpSuffix   = ".up.sql"
+	sqlDownSuffix = ".down.sql"
+)
+
+// sqlMigration runs the statements of a <version>_<name>.up.sql and
+// .down.sql file pair
+type sqlMigration struct {
+	key  string
+	up   string
+	down string
+	// hasUp and hasDown report which files of the pair exist
+	hasUp   bool
+	hasDown bool
+}
+
+func (m *sqlMigration) Up(db *gorm.DB) error {
+	return execSQLScript(db, m.up)
+}
+
+func (m *sqlMigration) Down(db *gorm.DB) error {
+	if !m.hasDown {
+		return fmt.Errorf("migration %s has no %s file", m.key, sqlDownSuffix)
+	}
+	return execSQLScript(db, m.down)
+}
+
+// missingDownFiles returns the keys of the SQL migrations among migrations
+// that have no .down.sql file and so cannot be rolled back
+func missingDownFiles(migrations []*identifiedMigration) []string {
+	var missing []string
+	for _, migration := range migrations {
+		if m, ok := migration.Migration.(*sqlMigration); ok && !m.hasDown {
+			missing = append(missing, migration.key())
+		}
+	}
+	return missing
+}
+
+// execSQLScript executes every statement of a script in order
+func execSQLScript(db *gorm.DB, script string) error {
+	for _, statement := range splitSQLStatements(script, db.Dialector.Name()) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSQLMigrations loads the .up.sql and .down.sql files of a directory of
+// fsys. Every .up.sql file is a migration, its .down.sql file is optional.
+func loadSQLMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	pairs := make(map[string]*sqlMigration)
+	for _, file := range files {
+		filename := file.Name()
+		if file.IsDir() {
+			continue
+		}
+
+		var key string
+		var isUp bool
+		switch {
+		case strings.HasSuffix(filename, sqlUpSuffix):
+			key, isUp = strings.TrimSuffix(filename, sqlUpSuffix), true
+		case strings.HasSuffix(filename, sqlDownSuffix):
+			key = strings.TrimSuffix(filename, sqlDownSuffix)
+		default:
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %w", filename, err)
+		}
+
+		pair, ok := pairs[key]
+		if !ok {
+			pair = &sqlMigration{key: key}
+			pairs[key] = pair
+		}
+		if isUp {
+			pair.up, pair.hasUp = string(content), true
+		} else {
+			pair.down, pair.hasDown = string(content), true
+		}
+	}
+
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	migrations := make([]Migration, 0, len(keys))
+	for _, key := range keys {
+		pair := pairs[key]
+
+		version, name, ok := strings.Cut(key, "_")
+		if !ok || version == "" {
+			return nil, fmt.Errorf("migration file %s does not follow the <version>_<name> naming convention", key)
+		}
+		if !pair.hasUp {
+			return nil, fmt.Errorf("migration %s has a %s file but no %s file", key, sqlDownSuffix, sqlUpSuffix)
+		}
+
+		checksum := checksumBytes([]byte(pair.up + "\x00" + pair.down))
+		migrations = append(migrations, withIdentity(pair, version, name, checksum))
+	}
+
+	return migrations, nil
+}
+
+// hasSQLMigrations reports whether a directory of fsys contains .up.sql files
+func hasSQLMigrations(fsys fs.FS, dir string) bool {
+	matches, _ := fs.Glob(fsys, path.Join(dir, "*"+sqlUpSuffix))
+	return len(matches) > 0
+}
+
+// sortByVersion orders migrations coming from several loaders by version.
+// Migrations without a version are kept so identifyMigrations reports them.
+func sortByVersion(migrations []Migration) {
+	sort.SliceStable(migrations, func(i, j int) bool {
+		vi, _ := migrationID(migrations[i])
+		vj, _ := migrationID(migrations[j])
+		return vi < vj
+	})
+}
+
+// delimiterPattern matches the DELIMITER command of the mysql client
+var delimiterPattern = regexp.MustCompile(`(?i)^DELIMITER[ \t]+(\S+)[ \t]*(\r?\n|$)`)
+
+// dollarQuotePattern matches the opening tag of a Postgres dollar-quoted string
+var dollarQuotePattern = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// splitSQLStatements splits a script into statements. Delimiters inside quoted
+// strings, identifiers, comments and Postgres dollar-quoted bodies are ignored,
+// and DELIMITER lines change the delimiter the way the mysql client does, so
+// stored procedures can be defined:
+//
+//	DELIMITER //
+//	CREATE PROCEDURE p() BEGIN SELECT 1; END //
+//	DELIMITER ;
+func splitSQLStatements(script, dialect string) []string {
+	mysql := dialect == "mysql"
+
+	var statements []string
+	var current strings.Builder
+	delimiter := ";"
+	// hasContent is false while the statement holds only whitespace and comments
+	hasContent := false
+	lineStart := true
+
+	flush := func() {
+		if hasContent {
+			statements = append(statements, strings.TrimSpace(current.String()))
+		}
+		current.Reset()
+		hasContent = false
+	}
+
+	for i := 0; i < len(script); {
+		rest := script[i:]
+		c := script[i]
+
+		// DELIMITER is only recognized at the start of a line between statements
+		if lineStart && !hasContent {
+			if match := delimiterPattern.FindStringSubmatch(rest); match != nil {
+				delimiter = match[1]
+				current.Reset()
+				i += len(match[0])
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(rest, delimiter):
+			flush()
+			i += len(delimiter)
+			lineStart = false
+			continue
+
+		case strings.HasPrefix(rest, "--") && (!mysql || len(rest) == 2 || isSpace(rest[2])),
+			mysql && c == '#':
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			current.WriteString(rest[:end])
+			i += end
+			lineStart = false
+			continue
+
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			current.WriteString(rest[:end])
+			i += end
+			lineStart = false
+			continue
+
+		case c == '\'' || c == '"' || c == '`':
+			// Postgres only honours backslashes in E'...' strings
+			escapes := mysql && c != '`' || c == '\'' && i > 0 && (script[i-1] == 'E' || script[i-1] == 'e')
+			end := quotedEnd(rest, c, escapes)
+			current.WriteString(rest[:end])
+			i += end
+			hasContent, lineStart = true, false
+			continue
+
+		case c == '$' && !mysql:
+			if match := dollarQuotePattern.FindString(rest); match != "" {
+				end := strings.Index(rest[len(match):], match)
+				if end < 0 {
+					end = len(rest)
+				} else {
+					end += 2 * len(match)
+				}
+				current.WriteString(rest[:end])
+				i += end
+				hasContent, lineStart = true, false
+				continue
+			}
+		}
+
+		current.WriteByte(c)
+		i++
+		if c == '\n' {
+			lineStart = true
+		} else if !isSpace(c) {
+			hasContent, lineStart = true, false
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// quotedEnd returns the length of the quoted string at the start of s. A
+// doubled quote character is part of the string, and so is a character
+// escaped with a backslash when backslashEscapes is set.
+func quotedEnd(s string, quote byte, backslashEscapes bool) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case backslashEscapes && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// isSpace reports whether c is an ASCII whitespace character
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,136 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		script  string
+		want    []string
+	}{
+		{
+			name:    "statements",
+			dialect: "mysql",
+			script:  "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:    []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:    "last statement without delimiter",
+			dialect: "postgres",
+			script:  "SELECT 1;\nSELECT 2",
+			want:    []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:    "empty statements",
+			dialect: "postgres",
+			script:  ";;\n  ;\n",
+			want:    nil,
+		},
+		{
+			name:    "single quotes",
+			dialect: "postgres",
+			script:  "INSERT INTO a VALUES ('x;y');INSERT INTO a VALUES ('it''s;');",
+			want:    []string{"INSERT INTO a VALUES ('x;y')", "INSERT INTO a VALUES ('it''s;')"},
+		},
+		{
+			name:    "mysql backslash escape",
+			dialect: "mysql",
+			script:  `INSERT INTO a VALUES ('x\';y');SELECT 1;`,
+			want:    []string{`INSERT INTO a VALUES ('x\';y')`, "SELECT 1"},
+		},
+		{
+			name:    "postgres backslash is literal",
+			dialect: "postgres",
+			script:  `INSERT INTO a VALUES ('x\');SELECT 1;`,
+			want:    []string{`INSERT INTO a VALUES ('x\')`, "SELECT 1"},
+		},
+		{
+			name:    "postgres escape string",
+			dialect: "postgres",
+			script:  `INSERT INTO a VALUES (E'x\';y');SELECT 1;`,
+			want:    []string{`INSERT INTO a VALUES (E'x\';y')`, "SELECT 1"},
+		},
+		{
+			name:    "quoted identifiers",
+			dialect: "mysql",
+			script:  "CREATE TABLE `a;b` (id INT);CREATE TABLE \"c;d\" (id INT);",
+			want:    []string{"CREATE TABLE `a;b` (id INT)", "CREATE TABLE \"c;d\" (id INT)"},
+		},
+		{
+			name:    "line comments",
+			dialect: "postgres",
+			script:  "-- first; table\nCREATE TABLE a (id INT); -- trailing;\n-- only a comment;\n",
+			want:    []string{"-- first; table\nCREATE TABLE a (id INT)"},
+		},
+		{
+			name:    "mysql hash comment",
+			dialect: "mysql",
+			script:  "# drop; later\nSELECT 1;",
+			want:    []string{"# drop; later\nSELECT 1"},
+		},
+		{
+			name:    "mysql double dash needs a space",
+			dialect: "mysql",
+			script:  "SELECT 1--1;SELECT 2;",
+			want:    []string{"SELECT 1--1", "SELECT 2"},
+		},
+		{
+			name:    "block comments",
+			dialect: "mysql",
+			script:  "/* a; b */ SELECT 1 /* c; */;\n/* only; */",
+			want:    []string{"/* a; b */ SELECT 1 /* c; */"},
+		},
+		{
+			name:    "dollar quoting",
+			dialect: "postgres",
+			script:  "CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  NEW.a := 1;\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\nSELECT 1;",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  NEW.a := 1;\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql",
+				"SELECT 1",
+			},
+		},
+		{
+			name:    "tagged dollar quoting",
+			dialect: "postgres",
+			script:  "DO $body$ BEGIN PERFORM '$$;'; END; $body$;SELECT $1;",
+			want:    []string{"DO $body$ BEGIN PERFORM '$$;'; END; $body$", "SELECT $1"},
+		},
+		{
+			name:    "mysql ignores dollar quoting",
+			dialect: "mysql",
+			script:  "SELECT '$$';SELECT $$;",
+			want:    []string{"SELECT '$$'", "SELECT $$"},
+		},
+		{
+			name:    "delimiter",
+			dialect: "mysql",
+			script:  "DELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END //\nDELIMITER ;\nCALL p();\n",
+			want:    []string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "CALL p()"},
+		},
+		{
+			name:    "delimiter is case insensitive",
+			dialect: "mysql",
+			script:  "delimiter $$\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.b = ';'; END$$\ndelimiter ;\n",
+			want:    []string{"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.b = ';'; END"},
+		},
+		{
+			name:    "delimiter only at statement start",
+			dialect: "mysql",
+			script:  "SELECT 1\nDELIMITER //\n;",
+			want:    []string{"SELECT 1\nDELIMITER //"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect+"/"+tt.name, func(t *testing.T) {
+			got := splitSQLStatements(tt.script, tt.dialect)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitSQLStatements(%q, %q)\ngot  %q\nwant %q", tt.script, tt.dialect, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MigrationStatus describes the state of a single migration
+type MigrationStatus struct {
+	Version   string
+	Name      string
+	Applied   bool
+	Batch     int
+	AppliedAt time.Time
+	// Missing is set for records whose migration is no longer loaded
+	Missing bool
+	// Modified is set for applied migrations whose checksum changed
+	Modified bool
+}
+
+// State returns Applied, Modified, Pending or Missing
+func (s MigrationStatus) State() string {
+	switch {
+	case s.Missing:
+		return "Missing"
+	case s.Modified:
+		return "Modified"
+	case s.Applied:
+		return "Applied"
+	default:
+		return "Pending"
+	}
+}
+
+// Status returns the state of every migration in the order they run,
+// followed by the records whose migration is no longer present. The
+// migrations table is only read.
+func Status(db *gorm.DB, migrations []Migration) ([]MigrationStatus, error) {
+	return defaultMigrator.withMigrations(db, migrations).Status()
+}
+
+// migrationStatus returns the state of every migration, reading the records
+// from table
+func migrationStatus(db *gorm.DB, table string, migrations []Migration) ([]MigrationStatus, error) {
+	identifiedMigrations, err := identifyMigrations(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	// A database that was never migrated has every migration pending
+	records, err := getAppliedRecords(db, table, identifiedMigrations, true)
+	if err != nil {
+		return nil, err
+	}
+
+	recordMap := make(map[string]MigrationRecord, len(records))
+	for _, record := range records {
+		recordMap[record.Version] = record
+	}
+
+	statuses := make([]MigrationStatus, 0, len(identifiedMigrations))
+	for _, migration := range identifiedMigrations {
+		status := MigrationStatus{Version: migration.version, Name: migration.name}
+		if record, ok := recordMap[migration.version]; ok {
+			status.Applied = true
+			status.Batch = record.Batch
+			status.AppliedAt = record.CreatedAt
+			status.Modified = record.Checksum != "" && migration.checksum != "" && record.Checksum != migration.checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, record := range findMissingRecords(identifiedMigrations, records) {
+		// Records nothing identifies show their stored migration name
+		version, name := record.Version, record.Name
+		if version == "" {
+			name = record.Migration
+		}
+		statuses = append(statuses, MigrationStatus{
+			Version:   version,
+			Name:      name,
+			Applied:   true,
+			Batch:     record.Batch,
+			AppliedAt: record.CreatedAt,
+			Missing:   true,
+		})
+	}
+
+	return statuses, nil
+}
+
+// printStatus writes the statuses as a table
+func printStatus(w io.Writer, statuses []MigrationStatus) error {
+	if len(statuses) == 0 {
+		_, err := fmt.Fprintln(w, "No migrations found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Migration\tStatus\tBatch\tApplied At")
+	for _, status := range statuses {
+		batch, appliedAt := "-", "-"
+		if status.Applied {
+			batch = fmt.Sprint(status.Batch)
+			appliedAt = status.AppliedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", migrationKey(status.Version, status.Name), status.State(), batch, appliedAt)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,357 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// splitTableName splits a schema qualified table name
+func splitTableName(table string) (schema, name string) {
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "", table
+}
+
+// hasTable reports whether a table exists, looking in its own schema when it
+// has one
+func hasTable(db *gorm.DB, table string) (bool, error) {
+	schema, name := splitTableName(table)
+
+	var query string
+	var args []interface{}
+	switch db.Dialector.Name() {
+	case "mysql", "postgres", "sqlserver":
+		schemaExpr := map[string]string{
+			"mysql":     "DATABASE()",
+			"postgres":  "CURRENT_SCHEMA()",
+			"sqlserver": "SCHEMA_NAME()",
+		}[db.Dialector.Name()]
+		if schema != "" {
+			schemaExpr = "?"
+			args = append(args, schema)
+		}
+		query = fmt.Sprintf("SELECT count(*) FROM information_schema.tables WHERE table_schema = %s AND table_name = ?", schemaExpr)
+	case "sqlite":
+		master := "sqlite_master"
+		if schema != "" {
+			master = db.Statement.Quote(schema) + ".sqlite_master"
+		}
+		query = fmt.Sprintf("SELECT count(*) FROM %s WHERE type = 'table' AND name = ?", master)
+	default:
+		return db.Migrator().HasTable(table), nil
+	}
+	args = append(args, name)
+
+	var count int64
+	if err := db.Raw(query, args...).Scan(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ensureMigrationsTable creates the migrations table with DDL written for the
+// dialect, or adds the columns older releases did not have to an existing
+// one. Dialects without DDL here fall back to AutoMigrate.
+func ensureMigrationsTable(db *gorm.DB, table string) error {
+	switch db.Dialector.Name() {
+	case "mysql", "postgres", "sqlite", "sqlserver":
+	default:
+		return db.Table(table).AutoMigrate(&MigrationRecord{})
+	}
+
+	exists, err := hasTable(db, table)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return upgradeMigrationsTable(db, table)
+	}
+
+	for _, statement := range createTableStatements(db, table) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTableStatements returns the DDL creating the migrations table. The
+// constraint and index names are the ones AutoMigrate used, so tables created
+// by older releases look the same.
+func createTableStatements(db *gorm.DB, table string) []string {
+	schema, name := splitTableName(table)
+	quote := db.Statement.Quote
+	unique := quote("uni_" + name + "_migration")
+
+	switch db.Dialector.Name() {
+	case "mysql":
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	migration VARCHAR(255) NOT NULL,
+	version VARCHAR(255),
+	name VARCHAR(255),
+	checksum VARCHAR(64),
+	batch BIGINT NOT NULL,
+	created_at DATETIME(3) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY %s (migration),
+	KEY %s (version)
+)`, quote(table), unique, quote("idx_"+name+"_version"))}
+
+	case "postgres":
+		statements := []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	migration VARCHAR(255) NOT NULL,
+	version VARCHAR(255),
+	name VARCHAR(255),
+	checksum VARCHAR(64),
+	batch BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	CONSTRAINT %s UNIQUE (migration)
+)`, quote(table), unique),
+			versionIndexStatement(db, table),
+		}
+		return withCreateSchema(db, schema, statements)
+
+	case "sqlserver":
+		statements := []string{fmt.Sprintf(`CREATE TABLE %s (
+	id BIGINT IDENTITY(1,1) NOT NULL PRIMARY KEY,
+	migration NVARCHAR(255) NOT NULL,
+	version NVARCHAR(255),
+	name NVARCHAR(255),
+	checksum NVARCHAR(64),
+	batch BIGINT NOT NULL,
+	created_at DATETIMEOFFSET NOT NULL,
+	CONSTRAINT %s UNIQUE (migration)
+)`, quote(table), unique),
+			versionIndexStatement(db, table),
+		}
+		return withCreateSchema(db, schema, statements)
+
+	default: // sqlite
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	migration TEXT NOT NULL,
+	version TEXT,
+	name TEXT,
+	checksum TEXT,
+	batch INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	CONSTRAINT %s UNIQUE (migration)
+)`, quote(table), unique),
+			versionIndexStatement(db, table),
+		}
+	}
+}
+
+// withCreateSchema prepends the DDL creating schema to statements on the
+// dialects that create schemas, SQLite attaches them instead
+func withCreateSchema(db *gorm.DB, schema string, statements []string) []string {
+	if schema == "" {
+		return statements
+	}
+	quote := db.Statement.Quote
+	switch db.Dialector.Name() {
+	case "postgres":
+		return append([]string{fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quote(schema))}, statements...)
+	case "sqlserver":
+		// CREATE SCHEMA must be the only statement of its batch
+		return append([]string{fmt.Sprintf("IF SCHEMA_ID(N'%s') IS NULL EXEC('CREATE SCHEMA %s')", schema, quote(schema))}, statements...)
+	}
+	return statements
+}
+
+// versionIndexStatement returns the DDL creating the index on the version
+// column of the migrations table
+func versionIndexStatement(db *gorm.DB, table string) string {
+	schema, name := splitTableName(table)
+	quote := db.Statement.Quote
+	index := "idx_" + name + "_version"
+
+	switch db.Dialector.Name() {
+	case "postgres":
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (version)", quote(index), quote(table))
+	case "sqlite":
+		// SQLite puts the schema on the index name rather than the table
+		if schema != "" {
+			return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (version)", quote(schema+"."+index), quote(name))
+		}
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (version)", quote(index), quote(table))
+	default:
+		return fmt.Sprintf("CREATE INDEX %s ON %s (version)", quote(index), quote(table))
+	}
+}
+
+// upgradeMigrationsTable adds the version, name and checksum columns to a
+// migrations table created by an older release
+func upgradeMigrationsTable(db *gorm.DB, table string) error {
+	rows, err := db.Table(table).Where("1 = 0").Rows()
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		existing[strings.ToLower(column)] = true
+	}
+
+	dialect := db.Dialector.Name()
+	addColumn := "ALTER TABLE %s ADD COLUMN %s %s"
+	varchar := "VARCHAR(%d)"
+	if dialect == "sqlserver" {
+		addColumn = "ALTER TABLE %s ADD %s %s"
+		varchar = "NVARCHAR(%d)"
+	}
+
+	for _, column := range []struct {
+		name string
+		size int
+	}{{"version", 255}, {"name", 255}, {"checksum", 64}} {
+		if existing[column.name] {
+			continue
+		}
+		statement := fmt.Sprintf(addColumn, db.Statement.Quote(table), column.name, fmt.Sprintf(varchar, column.size))
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to add column %s: %w", column.name, err)
+		}
+		if column.name == "version" {
+			if err := db.Exec(versionIndexStatement(db, table)).Error; err != nil {
+				return fmt.Errorf("failed to create version index: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ensureTable creates table when it is missing, with the DDL that statements
+// returns for the dialect, like the migrations table. Dialects without DDL
+// here fall back to AutoMigrate of model.
+func ensureTable(db *gorm.DB, table string, model interface{}, statements func(*gorm.DB, string) []string) error {
+	switch db.Dialector.Name() {
+	case "mysql", "postgres", "sqlite", "sqlserver":
+	default:
+		return db.Table(table).AutoMigrate(model)
+	}
+
+	exists, err := hasTable(db, table)
+	if err != nil || exists {
+		return err
+	}
+
+	for _, statement := range statements(db, table) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureSeedersTable creates the table run-once seeders are recorded in
+func ensureSeedersTable(db *gorm.DB, table string) error {
+	return ensureTable(db, table, &SeederRecord{}, createSeedersTableStatements)
+}
+
+// createSeedersTableStatements returns the DDL creating the seeders table
+func createSeedersTableStatements(db *gorm.DB, table string) []string {
+	schema, name := splitTableName(table)
+	quote := db.Statement.Quote
+	unique := quote("uni_" + name + "_seeder")
+
+	switch db.Dialector.Name() {
+	case "mysql":
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	seeder VARCHAR(255) NOT NULL,
+	created_at DATETIME(3) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY %s (seeder)
+)`, quote(table), unique)}
+
+	case "postgres":
+		return withCreateSchema(db, schema, []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	seeder VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	CONSTRAINT %s UNIQUE (seeder)
+)`, quote(table), unique)})
+
+	case "sqlserver":
+		return withCreateSchema(db, schema, []string{fmt.Sprintf(`CREATE TABLE %s (
+	id BIGINT IDENTITY(1,1) NOT NULL PRIMARY KEY,
+	seeder NVARCHAR(255) NOT NULL,
+	created_at DATETIMEOFFSET NOT NULL,
+	CONSTRAINT %s UNIQUE (seeder)
+)`, quote(table), unique)})
+
+	default: // sqlite
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	seeder TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	CONSTRAINT %s UNIQUE (seeder)
+)`, quote(table), unique)}
+	}
+}
+
+// ensureLocksTable creates the lease table of the table locker
+func ensureLocksTable(db *gorm.DB, table string) error {
+	return ensureTable(db, table, &MigrationLock{}, createLocksTableStatements)
+}
+
+// createLocksTableStatements returns the DDL creating the lock table
+func createLocksTableStatements(db *gorm.DB, table string) []string {
+	schema, _ := splitTableName(table)
+	quote := db.Statement.Quote
+
+	switch db.Dialector.Name() {
+	case "mysql":
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name VARCHAR(255) NOT NULL,
+	owner VARCHAR(255) NOT NULL,
+	expires_at DATETIME(3) NOT NULL,
+	PRIMARY KEY (name)
+)`, quote(table))}
+
+	case "postgres":
+		return withCreateSchema(db, schema, []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name VARCHAR(255) PRIMARY KEY,
+	owner VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+)`, quote(table))})
+
+	case "sqlserver":
+		return withCreateSchema(db, schema, []string{fmt.Sprintf(`CREATE TABLE %s (
+	name NVARCHAR(255) NOT NULL PRIMARY KEY,
+	owner NVARCHAR(255) NOT NULL,
+	expires_at DATETIMEOFFSET NOT NULL
+)`, quote(table))})
+
+	default: // sqlite
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name TEXT NOT NULL PRIMARY KEY,
+	owner TEXT NOT NULL,
+	expires_at DATETIME NOT NULL
+)`, quote(table))}
+	}
+}
+
+// insertInto returns db inserting into table. The SQLite drivers drop the
+// schema of the table from INSERT unless the table is named in the clause.
+func insertInto(db *gorm.DB, table string) *gorm.DB {
+	return db.Table(table).Clauses(clause.Insert{Table: clause.Table{Name: table}})
+}
+
+// dropMigrationsTable drops the migrations table, which dropAllTables misses
+// when it lives in another schema
+func dropMigrationsTable(db *gorm.DB, table string) error {
+	return db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", db.Statement.Quote(table))).Error
+}